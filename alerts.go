@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ruleFile is a Prometheus alerting rules file:
+// https://prometheus.io/docs/prometheus/latest/configuration/alerting_rules/
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string      `yaml:"name"`
+	Rules []alertRule `yaml:"rules"`
+}
+
+type alertRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// pmEnvironmental selects an environmental PM series in whichever schema
+// -merge-pm-metrics and -label-compat chose.
+func pmEnvironmental(microns string) string {
+	if *mergePMMetrics {
+		return fmt.Sprintf(`%s{calibration="environmental",microns=%q}`, pmMergedName, pmLabel(microns))
+	}
+	return fmt.Sprintf(`%s{microns=%q}`, pmEnvironmentalName, pmLabel(microns))
+}
+
+// highAQIExpr is true while the -alert-pollutant's AQI is above 100. The tops
+// of the US EPA "Moderate" breakpoints are where that happens.
+func highAQIExpr() string {
+	pm25 := fmt.Sprintf(`%s > %v`, pmEnvironmental("2.5"), pm25Breakpoints[1].concHi)
+	pm10 := fmt.Sprintf(`%s > %v`, pmEnvironmental("10"), pm10Breakpoints[1].concHi)
+	switch *alertPollutant {
+	case "pm10":
+		return pm10
+	case "max":
+		return fmt.Sprintf("(%s) or ignoring(microns) (%s)", pm25, pm10)
+	}
+	return pm25
+}
+
+// alertRules builds rules from our metric names, so they can't drift apart.
+func alertRules() ruleFile {
+	return ruleFile{Groups: []ruleGroup{{
+		Name: "breathe",
+		Rules: []alertRule{
+			// No For: the threshold already waits the 5 minutes, and For
+			// would wait another 5 on top.
+			{
+				Alert:       "PMSSensorStalled",
+				Expr:        fmt.Sprintf("time() - %s > 300", lastReadingTimestampName),
+				Labels:      map[string]string{"severity": "warning"},
+				Annotations: map[string]string{"summary": "PMS5003 on {{ $labels.instance }} hasn't produced a valid reading for 5 minutes"},
+			},
+			{
+				Alert:       "PMSSensorDisconnected",
+				Expr:        fmt.Sprintf("rate(%s[5m]) == 0", serialBytesReadName),
+				For:         "5m",
+				Labels:      map[string]string{"severity": "critical"},
+				Annotations: map[string]string{"summary": "Serial port on {{ $labels.instance }} has gone silent"},
+			},
+			{
+				Alert: "PMSHighChecksumErrorRate",
+				Expr: fmt.Sprintf("rate(%[1]s[10m]) / (rate(%[1]s[10m]) + rate(%[2]s[10m])) > 0.05",
+					checksumErrorsName, receivedPacketsName),
+				For:         "10m",
+				Labels:      map[string]string{"severity": "warning"},
+				Annotations: map[string]string{"summary": "More than 5% of packets from {{ $labels.instance }} fail their checksum"},
+			},
+			{
+				Alert:       "PMSHighAQI",
+				Expr:        highAQIExpr(),
+				For:         "15m",
+				Labels:      map[string]string{"severity": "warning"},
+				Annotations: map[string]string{"summary": "AQI at {{ $labels.instance }} is Unhealthy for Sensitive Groups or worse"},
+			},
+		},
+	}}}
+}
+
+func serveAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	enc.Encode(alertRules())
+	enc.Close()
+}
@@ -0,0 +1,96 @@
+package main
+
+import "math"
+
+// aqiBreakpoint maps a range of concentrations linearly onto a range of AQI.
+type aqiBreakpoint struct {
+	concLo, concHi float64
+	aqiLo, aqiHi   float64
+}
+
+// US EPA breakpoints, as revised in 2024:
+// https://www.epa.gov/system/files/documents/2024-02/pm-naaqs-air-quality-index-fact-sheet.pdf
+var (
+	pm25Breakpoints = []aqiBreakpoint{
+		{0.0, 9.0, 0, 50},
+		{9.1, 35.4, 51, 100},
+		{35.5, 55.4, 101, 150},
+		{55.5, 125.4, 151, 200},
+		{125.5, 225.4, 201, 300},
+		{225.5, 325.4, 301, 500},
+	}
+	pm10Breakpoints = []aqiBreakpoint{
+		{0, 54, 0, 50},
+		{55, 154, 51, 100},
+		{155, 254, 101, 150},
+		{255, 354, 151, 200},
+		{355, 424, 201, 300},
+		{425, 604, 301, 500},
+	}
+)
+
+// saturatedPM is the all-ones value some clone sensors report at boot, which
+// isn't a real concentration.
+const saturatedPM = 0xffff
+
+// aqi converts a concentration in micrograms per cubic meter to an AQI using
+// the given breakpoints. Concentrations beyond the last breakpoint are
+// reported as 500, the top of the scale. NaN, negative and infinite
+// concentrations have no AQI, and give NaN.
+func aqi(conc float64, breakpoints []aqiBreakpoint) float64 {
+	if math.IsNaN(conc) || math.IsInf(conc, 0) || conc < 0 {
+		return math.NaN()
+	}
+	for i, b := range breakpoints {
+		last := i == len(breakpoints)-1
+		if !last && conc >= breakpoints[i+1].concLo {
+			continue
+		}
+		if conc > b.concHi {
+			if last {
+				return b.aqiHi
+			}
+			// EPA truncates concentrations, so e.g. 9.05 belongs to the 9.0 breakpoint.
+			conc = b.concHi
+		}
+		if conc < b.concLo {
+			conc = b.concLo
+		}
+		return math.Round((b.aqiHi-b.aqiLo)/(b.concHi-b.concLo)*(conc-b.concLo) + b.aqiLo)
+	}
+	return 0
+}
+
+// alertAQI returns the AQI of whichever environmental PM value
+// -alert-pollutant chose, or the larger of the two for max.
+func alertAQI(pms *PMS5003) float64 {
+	pm25, pm10 := pm25AQI(pms.Pm25Env), pm10AQI(pms.Pm100Env)
+	switch *alertPollutant {
+	case "pm10":
+		return pm10
+	case "max":
+		// math.Max would return NaN if either is.
+		if math.IsNaN(pm25) || pm10 > pm25 {
+			return pm10
+		}
+	}
+	return pm25
+}
+
+// pm25AQI returns the AQI for a PM2.5 concentration, or NaN if the sensor
+// reported a saturated value.
+func pm25AQI(pm25 uint16) float64 {
+	if pm25 == saturatedPM {
+		return math.NaN()
+	}
+	return aqi(float64(pm25), pm25Breakpoints)
+}
+
+// pm10AQI returns the AQI for a PM10 concentration, or NaN if the sensor
+// reported a saturated value.
+func pm10AQI(pm10 uint16) float64 {
+	if pm10 == saturatedPM {
+		return math.NaN()
+	}
+	return aqi(float64(pm10), pm10Breakpoints)
+}
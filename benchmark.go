@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"time"
+)
+
+// encodeFrame returns p as the sensor would send it, magic bytes and all,
+// with the Length and Checksum fields filled in.
+func encodeFrame(p PMS5003) []byte {
+	p.Length = 28
+	var buf bytes.Buffer
+	m1, m2 := magic()
+	buf.Write([]byte{m1, m2})
+	binary.Write(&buf, binary.BigEndian, p)
+	b := buf.Bytes()
+	sum := uint16(0)
+	for _, c := range b[:30] {
+		sum += uint16(c)
+	}
+	binary.BigEndian.PutUint16(b[30:], sum)
+	return b
+}
+
+// benchmarkReadPMS parses n valid frames from memory as fast as it can and
+// reports the throughput and allocations per frame.
+func benchmarkReadPMS(n int) error {
+	var stream bytes.Buffer
+	for i := 0; i < n; i++ {
+		v := uint16(i)
+		stream.Write(encodeFrame(PMS5003{
+			Pm10Std: v, Pm25Std: v, Pm100Std: v,
+			Pm10Env: v, Pm25Env: v, Pm100Env: v,
+			Particles3um: v, Particles5um: v, Particles10um: v,
+			Particles25um: v, Particles50um: v, Particles100um: v,
+		}))
+	}
+	r := newPMSReader(bytes.NewReader(stream.Bytes()))
+
+	// readPMS logs every frame, which would dominate the measurement.
+	log.SetOutput(io.Discard)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	got := 0
+	for {
+		_, err := r.readPMS()
+		if errors.Is(err, errClosed) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", got, err)
+		}
+		got++
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	log.SetOutput(os.Stderr)
+
+	if got != n {
+		return fmt.Errorf("parsed %d frames, want %d", got, n)
+	}
+	fmt.Printf("%d frames in %v: %.0f frames/sec, %.1f allocs/frame, %.1f bytes/frame\n",
+		n, elapsed, float64(n)/elapsed.Seconds(),
+		float64(after.Mallocs-before.Mallocs)/float64(n),
+		float64(after.TotalAlloc-before.TotalAlloc)/float64(n))
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// BME280 datasheet: https://www.bosch-sensortec.com/media/boschsensortec/downloads/datasheets/bst-bme280-ds002.pdf
+
+const (
+	bme280ChipID   = 0x60
+	bme280Interval = 10 * time.Second
+
+	bme280RegCalib00  = 0x88
+	bme280RegChipID   = 0xd0
+	bme280RegCalib26  = 0xe1
+	bme280RegCtrlHum  = 0xf2
+	bme280RegCtrlMeas = 0xf4
+	bme280RegConfig   = 0xf5
+	bme280RegData     = 0xf7
+)
+
+var (
+	bme280_temperature_celsius = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "bme280_temperature_celsius",
+			Help: "Temperature from the auxiliary BME280 sensor",
+		},
+	)
+
+	bme280_relative_humidity_percent = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "bme280_relative_humidity_percent",
+			Help: "Relative humidity from the auxiliary BME280 sensor",
+		},
+	)
+
+	bme280_pressure_pascals = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "bme280_pressure_pascals",
+			Help: "Barometric pressure from the auxiliary BME280 sensor",
+		},
+	)
+
+	bme280_read_errors = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "bme280_read_errors",
+		},
+	)
+
+	humidityMu sync.Mutex
+	humidity   float64
+	humidityOK bool
+)
+
+// latestHumidity returns the last relative humidity read from the BME280, if
+// any.
+func latestHumidity() (float64, bool) {
+	humidityMu.Lock()
+	defer humidityMu.Unlock()
+	return humidity, humidityOK
+}
+
+// bme280Calibration holds the trimming parameters burned into each chip.
+type bme280Calibration struct {
+	T1                             uint16
+	T2, T3                         int16
+	P1                             uint16
+	P2, P3, P4, P5, P6, P7, P8, P9 int16
+	H1                             uint8
+	H2                             int16
+	H3                             uint8
+	H4, H5                         int16
+	H6                             int8
+}
+
+// readBME280Forever polls a BME280 on the given I2C bus until a fatal setup
+// error.
+func readBME280Forever(bus string, addr uint16) {
+	dev, err := openI2C(bus, addr)
+	if err != nil {
+		log.Printf("bme280: %v\n", err)
+		return
+	}
+	defer dev.Close()
+
+	cal, err := setupBME280(dev)
+	if err != nil {
+		log.Printf("bme280: %v\n", err)
+		return
+	}
+
+	for ; ; time.Sleep(bme280Interval) {
+		data, err := dev.readReg(bme280RegData, 8)
+		if err != nil {
+			log.Printf("bme280: %v\n", err)
+			bme280_read_errors.Inc()
+			continue
+		}
+		adcP := int32(data[0])<<12 | int32(data[1])<<4 | int32(data[2])>>4
+		adcT := int32(data[3])<<12 | int32(data[4])<<4 | int32(data[5])>>4
+		adcH := int32(data[6])<<8 | int32(data[7])
+		t, p, h := cal.compensate(adcT, adcP, adcH)
+		bme280_temperature_celsius.Set(t)
+		bme280_pressure_pascals.Set(p)
+		bme280_relative_humidity_percent.Set(h)
+
+		humidityMu.Lock()
+		humidity, humidityOK = h, true
+		humidityMu.Unlock()
+	}
+}
+
+// setupBME280 checks the chip ID, reads its calibration and starts continuous
+// sampling.
+func setupBME280(dev *i2cDevice) (*bme280Calibration, error) {
+	id, err := dev.readReg(bme280RegChipID, 1)
+	if err != nil {
+		return nil, err
+	}
+	if id[0] != bme280ChipID {
+		return nil, fmt.Errorf("chip id: got %#x want %#x", id[0], bme280ChipID)
+	}
+	c1, err := dev.readReg(bme280RegCalib00, 26)
+	if err != nil {
+		return nil, err
+	}
+	c2, err := dev.readReg(bme280RegCalib26, 7)
+	if err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	cal := &bme280Calibration{
+		T1: le.Uint16(c1[0:]),
+		T2: int16(le.Uint16(c1[2:])),
+		T3: int16(le.Uint16(c1[4:])),
+		P1: le.Uint16(c1[6:]),
+		P2: int16(le.Uint16(c1[8:])),
+		P3: int16(le.Uint16(c1[10:])),
+		P4: int16(le.Uint16(c1[12:])),
+		P5: int16(le.Uint16(c1[14:])),
+		P6: int16(le.Uint16(c1[16:])),
+		P7: int16(le.Uint16(c1[18:])),
+		P8: int16(le.Uint16(c1[20:])),
+		P9: int16(le.Uint16(c1[22:])),
+		H1: c1[25],
+		H2: int16(le.Uint16(c2[0:])),
+		H3: c2[2],
+		H4: int16(int8(c2[3]))<<4 | int16(c2[4]&0x0f),
+		H5: int16(int8(c2[5]))<<4 | int16(c2[4]>>4),
+		H6: int8(c2[6]),
+	}
+	// Humidity oversampling x1 must be written before ctrl_meas to take effect.
+	for _, w := range [][2]byte{
+		{bme280RegCtrlHum, 0x01},
+		{bme280RegConfig, 0xa0},   // 1000ms standby
+		{bme280RegCtrlMeas, 0x27}, // temperature and pressure x1, normal mode
+	} {
+		if err := dev.writeReg(w[0], w[1]); err != nil {
+			return nil, err
+		}
+	}
+	return cal, nil
+}
+
+// compensate converts raw readings into degrees Celsius, pascals and percent
+// relative humidity, using the floating point formulas from the datasheet.
+func (c *bme280Calibration) compensate(adcT, adcP, adcH int32) (t, p, h float64) {
+	var1 := (float64(adcT)/16384 - float64(c.T1)/1024) * float64(c.T2)
+	var2 := float64(adcT)/131072 - float64(c.T1)/8192
+	var2 = var2 * var2 * float64(c.T3)
+	tFine := var1 + var2
+	t = tFine / 5120
+
+	var1 = tFine/2 - 64000
+	var2 = var1 * var1 * float64(c.P6) / 32768
+	var2 = var2 + var1*float64(c.P5)*2
+	var2 = var2/4 + float64(c.P4)*65536
+	var1 = (float64(c.P3)*var1*var1/524288 + float64(c.P2)*var1) / 524288
+	var1 = (1 + var1/32768) * float64(c.P1)
+	if var1 != 0 {
+		p = 1048576 - float64(adcP)
+		p = (p - var2/4096) * 6250 / var1
+		var1 = float64(c.P9) * p * p / 2147483648
+		var2 = p * float64(c.P8) / 32768
+		p = p + (var1+var2+float64(c.P7))/16
+	}
+
+	h = tFine - 76800
+	h = (float64(adcH) - (float64(c.H4)*64 + float64(c.H5)/16384*h)) *
+		(float64(c.H2) / 65536 * (1 + float64(c.H6)/67108864*h*(1+float64(c.H3)/67108864*h)))
+	h = h * (1 - float64(c.H1)*h/524288)
+	if h > 100 {
+		h = 100
+	} else if h < 0 {
+		h = 0
+	}
+	return t, p, h
+}
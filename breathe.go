@@ -10,15 +10,23 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
+	"html/template"
 	"io"
+	"math"
+	"math/rand"
 	"net/http"
-	"text/template"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"time"
 
 	"log"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
@@ -26,35 +34,419 @@ import (
 )
 
 const (
-	magic1 = 0x42 // :)
-	magic2 = 0x4d
+	defaultMagic1 = 0x42 // :)
+	defaultMagic2 = 0x4d
+
+	// replayInterval paces replayed frames like a sensor in active mode.
+	replayInterval = time.Second
+)
+
+// Metric names referenced by the generated alerting rules.
+const (
+	receivedPacketsName      = "pms_received_packets"
+	checksumErrorsName       = "pms_packet_checksum_errors"
+	serialBytesReadName      = "pms_serial_bytes_read_total"
+	lastReadingTimestampName = "pms_last_reading_timestamp_seconds"
+	pmEnvironmentalName      = "pms_particulate_matter_environmental"
+	pmMergedName             = "pms_particulate_matter"
 )
 
+// errChecksum is returned by readPMS for a corrupt packet. Unlike read errors,
+// it's recoverable without reopening the port.
+var errChecksum = errors.New("checksum")
+
+// errCommandReply is returned by readPMS for the sensor's acknowledgement of
+// a command, which is recoverable too.
+var errCommandReply = errors.New("command reply")
+
+// errShortRead is returned by readPMS when the stream ends partway through a
+// frame.
+var errShortRead = errors.New("short read")
+
+// errClosed is returned by readPMS when the stream ends or the port is closed
+// between frames.
+var errClosed = errors.New("closed")
+
+// readError classifies err, from reading during what, as errShortRead or
+// errClosed where it's one of those, keeping err in the chain.
+func readError(what string, err error) error {
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%s: %w: %w", what, errShortRead, err)
+	case errors.Is(err, io.EOF), errors.Is(err, os.ErrClosed):
+		return fmt.Errorf("%s: %w: %w", what, errClosed, err)
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
+
+// midFrame turns io.EOF into io.ErrUnexpectedEOF, for reads after the magic
+// bytes, where the stream ending cuts a frame short.
+func midFrame(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
 var (
-	portname = flag.String("portname", "", "filename of serial port")
+	portname = flag.String("portname", "", "filename of serial port, or - to read recorded data from stdin")
+	loop     = flag.Bool("loop", false, "with -portname=- or -replay-csv, replay forever instead of exiting at the end")
+
+	replayCSVFile = flag.String("replay-csv", "", "instead of reading a sensor, export the readings in this CSV file, which has a timestamp column (RFC 3339 or Unix seconds) and columns named like pm25_env")
+	replaySpeed   = flag.Float64("replay-speed", 1, "how many times faster than recorded -replay-csv replays its readings")
 	// Port reserved at https://github.com/prometheus/prometheus/wiki/Default-port-allocations
 	port = flag.String("port", ":9662", "http port to listen on")
 
-	pms_received_packets = promauto.NewCounter(
+	configFile = flag.String("config", "", "YAML file of flag values, keyed by flag name. Flags on the command line override it")
+
+	checkConfig = flag.Bool("check-config", false, "validate flags, print any problems and exit")
+
+	debug = flag.Bool("debug", false, "serve debugging endpoints such as /config")
+
+	pathPrefix = flag.String("path-prefix", "", "serve every page under this path, e.g. /breathe for /breathe/metrics, when behind a reverse proxy")
+
+	metricsListen = flag.String("metrics-listen", "", "address to serve /metrics and health checks on. If only one of -metrics-listen and -ui-listen is set, everything is served there")
+	uiListen      = flag.String("ui-listen", "", "address to serve the HTML pages and debug endpoints on, e.g. localhost:9663")
+
+	pprofEnabled = flag.Bool("pprof", false, "serve profiling endpoints at /debug/pprof/")
+
+	openMetrics = flag.Bool("openmetrics", true, "serve OpenMetrics to scrapers that ask for it, as Prometheus does by default. It types counters whose names don't end in _total, like pms_received_packets, as unknown")
+
+	goMetrics = flag.Bool("go-metrics", false, "also export Go runtime and process metrics")
+
+	stateFile     = flag.String("state-file", "", "if set, persist the last valid reading to this file and restore it on startup")
+	stateInterval = flag.Duration("state-interval", time.Minute, "how often to write the latest reading to -state-file, which is also written on SIGINT and SIGTERM (0 writes every reading)")
+
+	maxReconnectsPerMinute = flag.Int("max-reconnects-per-minute", 6, "reopen the serial port at most this many times per minute before cooling down")
+	reconnectCooldown      = flag.Duration("reconnect-cooldown", 5*time.Minute, "how long to wait after exceeding -max-reconnects-per-minute")
+
+	bme280Bus  = flag.String("bme280-i2c-bus", "", "if set, read humidity from a BME280 on this I2C bus, e.g. /dev/i2c-1")
+	bme280Addr = flag.Uint("bme280-addr", 0x76, "I2C address of the BME280")
+
+	graphSamples = flag.Int("graph-samples", 3600, "number of readings kept in memory for /graph")
+
+	ignoreChecksum = flag.Bool("ignore-checksum", false, "export frames even if their checksum is wrong. For bringing up clone sensors only: data may be corrupt")
+
+	countSaturation = flag.Uint("count-saturation-threshold", 0, "treat particle counts at or above this value (e.g. 65535) as saturated rather than real (0 disables)")
+
+	warmupFrames = flag.Int("warmup-frames", 0, "discard this many valid frames after opening the serial port")
+
+	particleSizeHistogram = flag.Bool("particle-size-histogram", false, "also export the latest particle counts as pms_particle_size_microns, a histogram of diameter, for PromQL's histogram functions")
+
+	calibrate        = flag.Bool("calibrate", false, "for co-location with a reference instrument: serve /calibrate/observe, where a POST of the reference's pm25 with the -calibrate-token bearer token pairs it with the latest pms_pm25, and /calibrate/fit, the least-squares correction of the pairs so far")
+	applyCalibration = flag.Bool("apply-calibration", false, "with -calibrate, export pms_pm25 corrected by the fit as pms_pm25_calibrated")
+	calibrateToken   = flag.String("calibrate-token", "", "bearer token required to POST or DELETE /calibrate/observe")
+
+	nowcastEnabled = flag.Bool("nowcast", false, "export the US EPA NowCast of PM2.5 and its AQI, as AirNow shows. Needs a few hours of readings to start")
+
+	alertPollutant = flag.String("alert-pollutant", "pm25", "which environmental PM value drives pms_aqi_histogram and the PMSHighAQI alert: pm25, pm10, or max for whichever AQI is higher")
+
+	aqiHistogram = flag.Bool("aqi-histogram", false, "observe the PM2.5 AQI of every reading into pms_aqi_histogram")
+
+	stallTimeout   = flag.Duration("stall-timeout", time.Minute, "log when no valid reading has arrived for this long (0 disables)")
+	slowThreshold  = flag.Duration("slow-threshold", 0, "in -mode=active, warn and set pms_sensor_slow when no valid reading has arrived for this long (0 disables)")
+	deadThreshold  = flag.Duration("dead-threshold", 0, "in -mode=active, reopen the serial port when no valid reading has arrived for this long (0 disables)")
+	staleOnTimeout = flag.Bool("stale-on-timeout", false, "delete the reading series after -stall-timeout, so Prometheus marks them stale")
+
+	medianFilterEnabled = flag.Bool("median-filter", false, "export the per-field median of the last 3 readings, masking single bad frames")
+
+	exportSensorStatus = flag.Bool("export-sensor-status", false, "export the frame version and error code reported by the sensor")
+
+	minConsecutiveValid = flag.Int64("min-consecutive-valid", 0, "after opening the serial port, export nothing until this many consecutive valid frames arrive. Readiness also requires this many since the last checksum error")
+
+	startupDiscard = flag.Duration("startup-discard-duration", 0, "discard frames for this long after opening the serial port, while the fan spins up")
+
+	frameBuffer = flag.Int("frame-buffer", 16, "number of valid frames to buffer between the serial reader and the exporter")
+
+	graphiteAddr   = flag.String("graphite-addr", "", "if set, send each reading to this Carbon server in Graphite's plaintext protocol, e.g. localhost:2003")
+	graphitePrefix = flag.String("graphite-prefix", "breathe", "prefix for the Graphite metric paths, which are <prefix>.pms.<field>")
+
+	onChangeOnly    = flag.Bool("on-change-only", false, "only send readings to the outputs besides Prometheus, like -graphite-addr, when a PM value changed by more than -change-threshold, or -change-heartbeat passed")
+	changeThreshold = flag.Uint("change-threshold", 0, "with -on-change-only, how many micrograms per cubic meter a PM value must change by to publish")
+	changeHeartbeat = flag.Duration("change-heartbeat", time.Minute, "with -on-change-only, publish at least this often even if nothing changed")
+
+	suppressZeroCounts = flag.Bool("suppress-zero-counts", false, "leave zero particle counts out of what's sent to the outputs besides Prometheus, like -graphite-addr. Consumers must then treat a missing count as 0")
+
+	statsdAddr   = flag.String("statsd-addr", "", "if set, send each reading as StatsD gauges over UDP to this host:port, tagged in the DogStatsD format, e.g. localhost:8125")
+	statsdPrefix = flag.String("statsd-prefix", "breathe", "prefix for the StatsD metric names, which are <prefix>.pms.<metric>")
+
+	redisAddr   = flag.String("redis-addr", "", "if set, append each reading to a stream on this Redis server, e.g. localhost:6379")
+	redisStream = flag.String("redis-stream", "breathe", "Redis stream to append readings to")
+
+	// The PMS5003 has no command to read a serial number, so fleets must
+	// label units themselves.
+	sensorID = flag.String("sensor-id", "", "if set, export as the sensor_id label of pms_sensor_info, to tell physical units apart")
+
+	reassertActiveInterval = flag.Duration("reassert-active-interval", 0, "if set, re-send the active mode command this often, in case the sensor fell back to passive mode")
+
+	selftest        = flag.Bool("selftest", false, "before serving HTTP, check the sensor produces a valid reading within -selftest-timeout")
+	selftestTimeout = flag.Duration("selftest-timeout", 30*time.Second, "how long -selftest waits for a valid reading")
+	selftestFatal   = flag.Bool("selftest-fatal", true, "exit nonzero if -selftest fails, rather than just logging")
+
+	dedupFrames = flag.Bool("dedup-frames", false, "drop frames identical to the previous one. Steady air can legitimately repeat frames, so use with care")
+
+	indexTemplate = flag.String("index-template", "", "if set, render the landing page from this html/template file instead of the built-in one. The template is passed the portname")
+
+	maxConsecutiveChecksumErrors = flag.Int("max-consecutive-checksum-errors", 0, "reopen the serial port after this many checksum errors in a row, as the stream has likely desynced (0 disables)")
+
+	sensor = flag.String("sensor", "pms5003", "sensor model: pms5003, pms3003 for its older 20-byte frames without particle counts, or auto to tell from the first few frames")
+
+	mode              = flag.String("mode", "active", "active: the sensor streams readings continuously. passive: the sensor sleeps, waking to read for each scrape")
+	passiveWarmup     = flag.Duration("passive-warmup", 30*time.Second, "in -mode=passive, how long to let the fan run after waking before reading")
+	passiveFrames     = flag.Int("passive-frames", 1, "in -mode=passive, how many frames to read for each scrape")
+	scrapeReadTimeout = flag.Duration("scrape-read-timeout", 9*time.Second, "in -mode=passive, how long a scrape waits for fresh readings before serving the last ones, leaving the fresh ones for the next scrape. Keep it under Prometheus's scrape_timeout, 10s by default, which -passive-warmup alone exceeds (0 waits as long as it takes)")
+
+	laserRatedHours = flag.Float64("laser-rated-hours", 8000, "rated lifetime of the sensor's laser, for pms_laser_life_remaining_hours")
+
+	benchmark       = flag.Bool("benchmark", false, "parse -benchmark-frames in-memory frames as fast as possible, print the throughput and exit")
+	benchmarkFrames = flag.Int("benchmark-frames", 100000, "number of frames -benchmark parses")
+
+	magic1 = flag.Uint("magic1", defaultMagic1, "first start byte of each frame, e.g. 0x42. Some clone sensors use different ones")
+	magic2 = flag.Uint("magic2", defaultMagic2, "second start byte of each frame, e.g. 0x4d")
+
+	outdoorAPIURL    = flag.String("outdoor-api-url", "", "if set, poll this URL for JSON with the outdoor PM2.5 and AQI, exported as pms_outdoor_pm25 and pms_outdoor_aqi to compare with")
+	outdoorInterval  = flag.Duration("outdoor-interval", 10*time.Minute, "how often to poll -outdoor-api-url. Mind the API's rate limits")
+	outdoorPM25Field = flag.String("outdoor-pm25-field", "pm25", "dotted path to the PM2.5 in the -outdoor-api-url JSON, e.g. data.0.pm25 (empty to skip)")
+	outdoorAQIField  = flag.String("outdoor-aqi-field", "aqi", "dotted path to the AQI in the -outdoor-api-url JSON (empty to skip)")
+
+	pushgatewayURL = flag.String("pushgateway-url", "", "if set, also push metrics to the Pushgateway at this URL, e.g. http://localhost:9091, for when Prometheus can't scrape us")
+	pushJob        = flag.String("push-job", "breathe", "job label to push metrics under")
+	pushInterval   = flag.Duration("push-interval", 15*time.Second, "how often to push to -pushgateway-url")
+
+	skipNoncumulative = flag.Bool("skip-noncumulative", false, "don't export frames whose particle counts increase with size, which cumulative counts never do")
+	skipInconsistent  = flag.Bool("skip-inconsistent", false, "don't export frames whose environmental PM values exceed the standard ones, which a real sensor never reports")
+
+	corsOrigin = flag.String("cors-origin", "", "comma-separated origins allowed to fetch /json and /metrics from a browser, or * for any")
+
+	pmSource = flag.String("pm-source", "environmental", "which values feed the simple pms_pm25 and pms_pm10 gauges: standard, environmental or average")
+
+	protobufOutput = flag.String("protobuf-output", "", "if set, write each reading as a length-delimited Reading message (see reading.proto) to this file, or to a tcp://host:port or unix:///path socket")
+
+	continueWithoutHTTP = flag.Bool("continue-without-http", false, "if serving HTTP fails, e.g. because the port is taken, keep reading the sensor for the other outputs rather than exiting")
+
+	maintenanceToken = flag.String("maintenance-token", "", "if set, serve /maintenance, where a POST with this bearer token pauses exporting readings while the sensor is serviced")
+
+	startJitter = flag.Duration("start-jitter", 0, "wait a random time up to this long before serving HTTP, so a fleet started together doesn't read in lockstep")
+
+	labelCompat = flag.String("label-compat", "breathe", "naming scheme for the size labels, to match another exporter's dashboards: breathe (microns=\"2.5\", microns_lower_bound=\"25\"), decimal (microns_lower_bound=\"2.5\"), or pm (microns=\"pm2_5\", microns_lower_bound=\"pm2_5\")")
+
+	timestampPMMetrics = flag.Bool("timestamp-pm-metrics", false, "stamp the PM metrics with when the reading was taken rather than leaving Prometheus to use the scrape time")
+	mergePMMetrics     = flag.Bool("merge-pm-metrics", false, "export PM values as one pms_particulate_matter family with a calibration label, instead of separate _standard and _environmental families")
+
+	systemdNotify = flag.Bool("systemd-notify", false, "for Type=notify units: tell systemd we're ready after the first valid reading, and ping WatchdogSec= while readings keep arriving")
+
+	summaryInterval = flag.Duration("summary-interval", 0, "if set, log the latest reading and packet counts this often (0 disables)")
+
+	maxPlausiblePM = flag.Uint("max-plausible-pm", 1000, "reject frames with any PM value above this many micrograms per cubic meter (0 disables)")
+
+	// registry holds every metric we export. We avoid the global default
+	// registry so the Go runtime and process collectors are opt-in.
+	registry = prometheus.NewRegistry()
+
+	pms_received_packets = promauto.With(registry).NewCounter(
 		prometheus.CounterOpts{
-			Name: "pms_received_packets",
+			Name: receivedPacketsName,
 		},
 	)
 
-	pms_packet_checksum_errors = promauto.NewCounter(
+	pms_packet_checksum_errors = promauto.With(registry).NewCounter(
 		prometheus.CounterOpts{
-			Name: "pms_packet_checksum_errors",
+			Name: checksumErrorsName,
 		},
 	)
 
-	pms_skipped_bytes = promauto.NewCounter(
+	pms_skipped_bytes = promauto.With(registry).NewCounter(
 		prometheus.CounterOpts{
 			Name: "pms_skipped_bytes",
 		},
 	)
 
+	pms_false_sync_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_false_sync_total",
+			Help: "Checksum errors in frames found only after skipping bytes, which likely matched the magic bytes inside data rather than at a frame start",
+		},
+	)
+
+	pms_resync_duration_seconds = promauto.With(registry).NewHistogram(
+		prometheus.HistogramOpts{
+			Name: "pms_resync_duration_seconds",
+			Help: "Time spent waiting for each frame's magic bytes, including waiting for the sensor to send. In active mode expect about a second; much longer means skipped bytes or a stalled sensor",
+			// A byte takes about 1ms at 9600 baud.
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 9),
+		},
+	)
+
+	pms_count_saturation_total = promauto.With(registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pms_count_saturation_total",
+			Help: "Particle counts at or above -count-saturation-threshold, which likely pegged the sensor rather than being real",
+		},
+		[]string{"microns_lower_bound"},
+	)
+
+	pms_reading_suspect = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_reading_suspect",
+			Help: "1 if the current reading has saturated particle counts, else 0",
+		},
+	)
+
+	pms_serial_reconnects_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_serial_reconnects_total",
+			Help: "Times the serial port was reopened after reading it failed",
+		},
+	)
+
+	pms_reconnect_rate_limited_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_reconnect_rate_limited_total",
+			Help: "Times reopening the serial port was delayed by -reconnect-cooldown",
+		},
+	)
+
+	pms_serial_bytes_read_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: serialBytesReadName,
+			Help: "Bytes read from the serial port, whether skipped or part of a frame",
+		},
+	)
+
+	pms_frame_length = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_frame_length",
+			Help: "Length field of the last frame read, valid or not. PMS5003 frames are 28, PMS3003 frames 20",
+		},
+	)
+
+	pms_invalid_length_frames_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_invalid_length_frames_total",
+			Help: "Frames ignored because their length field was unexpected",
+		},
+	)
+
+	pms_serial_info = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pms_serial_info",
+			Help: "Always 1. Labels describe the serial port settings",
+		},
+		[]string{"portname", "baudrate", "databits", "stopbits"},
+	)
+
+	pms_serial_session_duration_seconds = promauto.With(registry).NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "pms_serial_session_duration_seconds",
+			Help: "How long the serial port has been open, 0 while it's closed",
+		},
+		func() float64 {
+			start := sessionStart.Load()
+			if start == 0 {
+				return 0
+			}
+			return time.Since(time.Unix(0, start)).Seconds()
+		},
+	)
+
+	pms_serial_completed_session_duration_seconds = promauto.With(registry).NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "pms_serial_completed_session_duration_seconds",
+			Help:    "How long the serial port stayed open before each reconnect. Many short sessions mean flapping hardware, like a loose USB cable",
+			Buckets: []float64{1, 10, 60, 600, 3600, 6 * 3600, 24 * 3600, 7 * 24 * 3600},
+		},
+	)
+
+	pms_sensor_info = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pms_sensor_info",
+			Help: "Always 1. Labels identify the physical sensor",
+		},
+		[]string{"sensor_id"},
+	)
+
+	pms_mode_info = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pms_mode_info",
+			Help: "Always 1. Labels show how readings are collected: -mode, -sensor, and in passive mode -passive-warmup and -passive-frames",
+		},
+		[]string{"mode", "sensor", "passive_warmup", "passive_frames"},
+	)
+
+	pms_duplicate_frames_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_duplicate_frames_total",
+			Help: "Frames dropped by -dedup-frames for repeating the previous frame",
+		},
+	)
+
+	pms_checksum_unchanged_streak = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_checksum_unchanged_streak",
+			Help: "Consecutive valid frames with the same checksum as the one before. A long streak suggests a sensor stuck repeating one frame",
+		},
+	)
+
+	pms_desync_recoveries_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_desync_recoveries_total",
+			Help: "Times the serial port was reopened after -max-consecutive-checksum-errors",
+		},
+	)
+
+	pms_implausible_frames_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_implausible_frames_total",
+			Help: "Frames that passed the checksum but carried implausibly large PM values",
+		},
+	)
+
+	pms_inconsistent_frames_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_inconsistent_frames_total",
+			Help: "Frames that passed the checksum but whose environmental PM values exceed the standard ones",
+		},
+	)
+
+	pms_noncumulative_frames_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_noncumulative_frames_total",
+			Help: "Frames that passed the checksum but count more particles at a larger size than at a smaller one",
+		},
+	)
+
+	pms_last_reading_timestamp_seconds = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: lastReadingTimestampName,
+			Help: "Unix time of the reading currently exported. Readings restored from -state-file keep their original time.",
+		},
+	)
+
+	breathe_scrapes_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "breathe_scrapes_total",
+			Help: "Requests served by /metrics",
+		},
+	)
+
+	pms_frames_since_last_scrape = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_frames_since_last_scrape",
+			Help: "Readings exported between the previous scrape and this one. Much more than 1 means most readings are never scraped. Only meaningful with a single scraper",
+		},
+	)
+
+	breathe_last_scrape_timestamp_seconds = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "breathe_last_scrape_timestamp_seconds",
+			Help: "Unix time of the previous request to /metrics",
+		},
+	)
+
 	// https://cdn-shop.adafruit.com/product-files/3686/plantower-pms5003-manual_v2-3.pdf
-	pms_particulate_matter_standard = promauto.NewGaugeVec(
+	// Only one of the two standard and environmental vecs or the merged
+	// pms_particulate_matter is registered, depending on -merge-pm-metrics.
+	pms_particulate_matter_standard = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "pms_particulate_matter_standard",
 			Help: "Micrograms per cubic meter, standard particle",
@@ -63,16 +455,94 @@ var (
 	)
 
 	// https://cdn-shop.adafruit.com/product-files/3686/plantower-pms5003-manual_v2-3.pdf
-	pms_particulate_matter_environmental = promauto.NewGaugeVec(
+	pms_particulate_matter_environmental = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "pms_particulate_matter_environmental",
+			Name: pmEnvironmentalName,
 			Help: "micrograms per cubic meter, adjusted for atmospheric environment",
 		},
 		[]string{"microns"},
 	)
 
+	pms_particulate_matter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: pmMergedName,
+			Help: "Micrograms per cubic meter, standard particle or adjusted for atmospheric environment",
+		},
+		[]string{"calibration", "microns"},
+	)
+
+	pms_env_std_ratio = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pms_env_std_ratio",
+			Help: "Environmental PM divided by standard PM, showing how much the sensor's atmospheric correction applies. Absent while the standard value is 0",
+		},
+		[]string{"microns"},
+	)
+
+	// https://www.epa.gov/sites/default/files/2021-05/documents/toolsresourceswebinar_purpleairsmoke_210519b.pdf
+	pms_pm25_humidity_corrected = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_pm25_humidity_corrected",
+			Help: "Micrograms per cubic meter of PM2.5, corrected for humidity with the US EPA formula (requires -bme280-i2c-bus)",
+		},
+	)
+
+	pms_pm25 = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_pm25",
+			Help: "Micrograms per cubic meter of PM2.5, from the values chosen by -pm-source",
+		},
+	)
+
+	pms_pm25_delta = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_pm25_delta",
+			Help: "Change in environmental PM2.5 since the previous reading, in micrograms per cubic meter. Sudden jumps mean events like cooking",
+		},
+	)
+
+	pms_pm10 = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_pm10",
+			Help: "Micrograms per cubic meter of PM10, from the values chosen by -pm-source",
+		},
+	)
+
+	pms_sensor_version = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_sensor_version",
+			Help: "Frame version reported by the sensor",
+		},
+	)
+
+	pms_sensor_error_code = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_sensor_error_code",
+			Help: "Error code reported by the sensor, 0 if healthy",
+		},
+	)
+
+	// The EPA formula expects atmospheric concentrations, so calibration="env"
+	// is the one to compare with official AQI. calibration="std" uses the
+	// CF=1 standard particle values and is exported for completeness.
+	pms_aqi = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pms_aqi",
+			Help: "US EPA Air Quality Index. calibration=\"env\" uses atmospheric PM values as the EPA intends; \"std\" uses standard particle values",
+		},
+		[]string{"particle_type", "calibration"},
+	)
+
+	pms_aqi_histogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "pms_aqi_histogram",
+			Help:    "US EPA AQI of the environmental PM value chosen by -alert-pollutant, observed every reading. Buckets are the AQI category boundaries",
+			Buckets: []float64{0, 50, 100, 150, 200, 300, 500},
+		},
+	)
+
 	// https://cdn-shop.adafruit.com/product-files/3686/plantower-pms5003-manual_v2-3.pdf
-	pms_particle_counts = promauto.NewGaugeVec(
+	pms_particle_counts = promauto.With(registry).NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "pms_particle_counts",
 			Help: "Number of particles with diameter beyond given number of microns in 0.1L of air",
@@ -80,11 +550,31 @@ var (
 		[]string{"microns_lower_bound"},
 	)
 
+	// previousPM25 is the environmental PM2.5 of the last exported reading,
+	// for pms_pm25_delta, or -1 before the first one.
+	previousPM25 = -1
+
+	// nowcastPM25 averages PM2.5 hourly when -nowcast is set.
+	nowcastPM25 *nowcast
+
+	// median filters readings when -median-filter is set.
+	median medianFilter
+
+	// sequence counts valid frames read since startup.
+	sequence uint64
+
+	// history holds recent readings for /graph.
+	history *sampleRing
+
+	// index is an html/template, so the portname and any user-supplied
+	// -index-template content are escaped.
 	index = template.Must(template.New("index").Parse(
 		`<!doctype html>
 	 <title>PMS5003 Prometheus Exporter</title>
 	 <h1>PMS5003 Prometheus Exporter</h1>
-	 <a href="/metrics">Metrics</a>
+	 <a href="metrics">Metrics</a>
+	 <a href="graph">Graph</a>
+	 <a href="json">JSON</a>
 	 <p>
 	 <pre>portname={{.}}</pre>
 	 `))
@@ -92,17 +582,275 @@ var (
 
 func main() {
 	flag.Parse()
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "loading -config:\n%v\n", err)
+			os.Exit(1)
+		}
+	}
+	if done, err := runCommand(); err != nil {
+		log.Fatal(err)
+	} else if done {
+		return
+	}
+	if *portname == "" && !*benchmark && *replayCSVFile == "" {
+		// The usual first-run mistake, so say what to do rather than let
+		// serial.Open fail on "".
+		fmt.Fprintf(os.Stderr, "No serial port given. Set -portname to the sensor's serial device, e.g. -portname=/dev/serial0, or -portname=- to read recorded data from stdin.\n\nUsage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := validateFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid flags:\n%v\n", err)
+		os.Exit(1)
+	}
+	if *indexTemplate != "" {
+		// validateFlags checked this parses.
+		index = template.Must(template.ParseFiles(*indexTemplate))
+	}
+	if *checkConfig {
+		fmt.Println("flags OK")
+		return
+	}
+	if *benchmark {
+		if err := benchmarkReadPMS(*benchmarkFrames); err != nil {
+			log.Fatalf("benchmark: %v", err)
+		}
+		return
+	}
 	log.Printf("PMS Prometheus Exporter starting on port %v and file %v\n", *port, *portname)
-	go readPortForever()
-	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	if *goMetrics {
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+	pmCollectors := []prometheus.Collector{pms_particulate_matter_standard, pms_particulate_matter_environmental}
+	if *mergePMMetrics {
+		pmCollectors = []prometheus.Collector{pms_particulate_matter}
+	}
+	for _, c := range pmCollectors {
+		if *timestampPMMetrics {
+			c = timestampedCollector{c}
+		}
+		registry.MustRegister(c)
+	}
+	if *timestampPMMetrics {
+		log.Println("WARNING: -timestamp-pm-metrics is set. Prometheus never marks timestamped series stale, and rejects samples too old for its head block.")
+	}
+	if *aqiHistogram {
+		registry.MustRegister(pms_aqi_histogram)
+	}
+	if *calibrate {
+		registry.MustRegister(pms_calibration_samples, pms_calibration_slope, pms_calibration_intercept, pms_calibration_r_squared)
+		calibration = &calibrationFit{}
+		updateCalibrationMetrics()
+	}
+	if *applyCalibration {
+		registry.MustRegister(pms_pm25_calibrated)
+	}
+	if *nowcastEnabled {
+		registry.MustRegister(pms_nowcast_pm25, pms_nowcast_aqi)
+		nowcastPM25 = newNowcast()
+	}
+	if *particleSizeHistogram {
+		registry.MustRegister(newParticleSizeCollector())
+	}
+	if *exportSensorStatus {
+		registry.MustRegister(pms_sensor_version, pms_sensor_error_code)
+	}
+	if *dedupFrames {
+		log.Println("WARNING: -dedup-frames is set. Genuinely steady air repeats frames too, and those will be dropped.")
+	}
+	setModeInfo(sensorModel())
+	if *sensorID != "" {
+		pms_sensor_info.WithLabelValues(*sensorID).Set(1)
+	}
+	if *ignoreChecksum {
+		log.Println("WARNING: -ignore-checksum is set. Frames with bad checksums will be exported and data may be corrupt!")
+	}
+	// Set the laser life gauges before any runtime accrues.
+	addRuntime(0)
+	if *stateFile != "" {
+		restoreState(*stateFile)
+		go saveStateOnExit(*stateFile)
+	}
+	if *bme280Bus != "" {
+		go readBME280Forever(*bme280Bus, uint16(*bme280Addr))
+	}
+	history = newSampleRing(*graphSamples)
+	if *configFile != "" {
+		go reloadOnSIGHUP(*configFile)
+	}
+	if *stallTimeout > 0 {
+		go watchForStalls(*stallTimeout)
+	}
+	if *slowThreshold > 0 || *deadThreshold > 0 {
+		go watchCadence(*slowThreshold, *deadThreshold)
+	}
+	if *summaryInterval > 0 {
+		go logSummaries(*summaryInterval)
+	}
+	if *outdoorAPIURL != "" {
+		registry.MustRegister(pms_outdoor_pm25, pms_outdoor_aqi, pms_outdoor_last_fetch_timestamp_seconds, pms_outdoor_errors_total)
+		go pollOutdoor(*outdoorAPIURL, *outdoorInterval)
+	}
+	if *pushgatewayURL != "" {
+		go pushForever(*pushgatewayURL, *pushJob, *pushInterval)
+	}
+	sinks = newSinks()
+	if *onChangeOnly {
+		sinkFilter = &changeFilter{threshold: *changeThreshold, heartbeat: *changeHeartbeat}
+	}
+	frames := newFrameQueue(*frameBuffer)
+	go restartOnPanic(pms_export_panics_total, func() { exportFrames(frames) })
+	start := time.Now()
+	if *replayCSVFile != "" {
+		go replayCSV(*replayCSVFile, *replaySpeed)
+	} else {
+		go restartOnPanic(pms_read_panics_total, func() { readPortForever(frames) })
+	}
+	if *systemdNotify {
+		go notifySystemd(start)
+	}
+	if *selftest {
+		l, err := selfTest(start, *selftestTimeout)
+		switch {
+		case err == nil:
+			log.Printf("Self-test passed: %+v\n", l.Reading)
+		case *selftestFatal:
+			log.Fatalf("Self-test failed: %v", err)
+		default:
+			log.Printf("WARNING: self-test failed: %v\n", err)
+		}
+	}
+	if *startJitter > 0 {
+		d := time.Duration(rand.Int63n(int64(*startJitter)))
+		log.Printf("Waiting %v before serving (-start-jitter).\n", d)
+		time.Sleep(d)
+	}
+	metricsAddr, uiAddr := listenAddrs()
+	// Use our own muxes: importing net/http/pprof registers handlers on the
+	// default one.
+	metricsMux := http.NewServeMux()
+	uiMux := metricsMux
+	if metricsAddr != uiAddr {
+		uiMux = http.NewServeMux()
+	}
+	metricsHandler := newMetricsHandler(registry)
+	pmsMetricsHandler := newMetricsHandler(pmsGatherer{registry})
+	var jsonHandler http.Handler = http.HandlerFunc(serveJSON)
+	if origins := corsOrigins(); len(origins) > 0 {
+		metricsHandler = allowCORS(origins, metricsHandler)
+		pmsMetricsHandler = allowCORS(origins, pmsMetricsHandler)
+		jsonHandler = allowCORS(origins, jsonHandler)
+	}
+	metricsMux.Handle("/metrics", metricsHandler)
+	metricsMux.Handle("/metrics/pms", pmsMetricsHandler)
+	metricsMux.HandleFunc("/healthz", serveHealthz)
+	metricsMux.HandleFunc("/readyz", serveReadyz)
+	uiMux.HandleFunc("/graph", serveGraph)
+	uiMux.Handle("/json", jsonHandler)
+	uiMux.HandleFunc("/alerts.yaml", serveAlerts)
+	if *pprofEnabled {
+		uiMux.HandleFunc("/debug/pprof/", pprof.Index)
+		uiMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		uiMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		uiMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		uiMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	if *debug {
+		uiMux.HandleFunc("/config", serveConfig)
+	}
+	if *calibrate {
+		uiMux.HandleFunc("/calibrate/observe", serveCalibrateObserve)
+		uiMux.HandleFunc("/calibrate/fit", serveCalibrateFit)
+	}
+	if *maintenanceToken != "" {
+		uiMux.HandleFunc("/maintenance", serveMaintenance)
+	}
+	uiMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		index.Execute(w, *portname)
 	})
-	http.ListenAndServe(*port, nil)
+	log.Printf("Serving metrics on %v and UI on %v\n", metricsAddr, uiAddr)
+	servers := []*http.Server{{Addr: metricsAddr, Handler: withPathPrefix(*pathPrefix, metricsMux)}}
+	if metricsAddr != uiAddr {
+		servers = append(servers, &http.Server{Addr: uiAddr, Handler: withPathPrefix(*pathPrefix, uiMux)})
+	}
+	err := serve(servers)
+	if *continueWithoutHTTP {
+		log.Printf("WARNING: not serving HTTP, but still reading the sensor (-continue-without-http): %v\n", err)
+		select {}
+	}
+	log.Fatalf("Serving HTTP: %v", err)
+}
+
+// newMetricsHandler serves the metrics gathered by g, counting each scrape and
+// in passive mode reading the sensor for it. promhttp gzips the response for
+// scrapers that accept it, in either format.
+func newMetricsHandler(g prometheus.Gatherer) http.Handler {
+	var h http.Handler = promhttp.HandlerFor(g, promhttp.HandlerOpts{EnableOpenMetrics: *openMetrics})
+	if *mode == "passive" {
+		h = pollOnScrape(h, *scrapeReadTimeout)
+	}
+	return countScrapes(h)
+}
+
+// countScrapes records each request before passing it to h. The timestamp is
+// set after serving, so a scrape sees when the previous one happened.
+func countScrapes(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		breathe_scrapes_total.Inc()
+		pms_frames_since_last_scrape.Set(float64(framesSinceScrape.Swap(0)))
+		h.ServeHTTP(w, r)
+		breathe_last_scrape_timestamp_seconds.SetToCurrentTime()
+	})
+}
+
+// readPortForever reads the serial port into frames, reopening it whenever
+// reading fails.
+func readPortForever(frames frameQueue) {
+	if *portname == "-" {
+		readStdin(frames)
+		return
+	}
+	limiter := newReconnectLimiter(*maxReconnectsPerMinute, time.Minute)
+	backoff := minReconnectBackoff
+	for first := true; ; first = false {
+		if !first {
+			pms_serial_reconnects_total.Inc()
+			if !limiter.allow(time.Now()) {
+				log.Printf("WARNING: more than %d serial reconnects in the last minute. Cooling down for %v to avoid wedging the USB hub.\n", *maxReconnectsPerMinute, *reconnectCooldown)
+				pms_reconnect_rate_limited_total.Inc()
+				time.Sleep(*reconnectCooldown)
+			}
+		}
+		err := readPort(frames)
+		switch {
+		case errors.Is(err, errClosed):
+			log.Printf("readPort: the serial port closed, perhaps the sensor was unplugged: %v\n", err)
+		case errors.Is(err, errShortRead):
+			log.Printf("readPort: a frame was cut short: %v\n", err)
+		default:
+			log.Printf("readPort: %v\n", err)
+		}
+		// A session that produced readings was healthy, so whatever ended it
+		// is new trouble rather than more of the same.
+		if sessionReadings.Swap(0) > 0 {
+			backoff = minReconnectBackoff
+		}
+		log.Printf("Reopening the serial port in %v.\n", backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
 }
 
-func readPortForever() {
+// readPort opens the serial port and queues packets from it until a read
+// fails.
+func readPort(frames frameQueue) error {
 	options := serial.OpenOptions{
 		PortName:        *portname,
 		BaudRate:        9600,
@@ -113,37 +861,338 @@ func readPortForever() {
 
 	port, err := serial.Open(options)
 	if err != nil {
-		log.Fatalf("serial.Open: %v", err)
+		return fmt.Errorf("serial.Open: %w", err)
+	}
+	if port, err = pollable(port); err != nil {
+		return err
 	}
 
 	defer port.Close()
+	opened := time.Now()
+	sessionStart.Store(opened.UnixNano())
+	defer func() {
+		sessionStart.Store(0)
+		pms_serial_completed_session_duration_seconds.Observe(time.Since(opened).Seconds())
+	}()
+	pms_serial_info.WithLabelValues(
+		options.PortName,
+		strconv.Itoa(int(options.BaudRate)),
+		strconv.Itoa(int(options.DataBits)),
+		strconv.Itoa(int(options.StopBits)),
+	).Set(1)
+	portOpen.Store(true)
+	defer portOpen.Store(false)
+	setOpenPort(port)
+	defer setOpenPort(nil)
+	if *reassertActiveInterval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go reassertActiveMode(port, *reassertActiveInterval, done)
+	}
+	overrunsDone := make(chan struct{})
+	defer close(overrunsDone)
+	go watchOverruns(port, overrunsDone)
+	if *mode == "passive" {
+		stop := make(chan struct{})
+		defer close(stop)
+		go pollPassive(port, stop)
+	}
+	return readFrames(port, frames, 0)
+}
+
+// readStdin queues packets from stdin, for piping in recorded data. At EOF it
+// exits, or with -loop replays what it read forever.
+func readStdin(frames frameQueue) {
+	portOpen.Store(true)
+	var recording bytes.Buffer
+	var r io.Reader = os.Stdin
+	if *loop {
+		r = io.TeeReader(os.Stdin, &recording)
+	}
+	for pace := time.Duration(0); ; pace = replayInterval {
+		err := readFrames(r, frames, pace)
+		if !errors.Is(err, errClosed) && !errors.Is(err, errShortRead) {
+			log.Fatalf("readFrames: %v", err)
+		}
+		if !*loop {
+			log.Println("End of stdin.")
+			os.Exit(0)
+		}
+		if recording.Len() == 0 {
+			log.Fatal("Nothing to replay: stdin was empty.")
+		}
+		log.Println("End of stdin, replaying.")
+		r = bytes.NewReader(recording.Bytes())
+	}
+}
+
+// readFrames queues packets from r until a read fails, pausing for pace
+// after each one.
+func readFrames(port io.Reader, frames frameQueue, pace time.Duration) error {
+	sessionReadings.Store(0)
+	r := newPMSReader(countingReader{port})
+	warmup := *warmupFrames
+	consecutiveValid.Store(0)
+	gated := *minConsecutiveValid > 0
+	var previous PMS5003
+	streak := 0
+	var lastValid time.Time
+	checksumErrors := 0
+	warmupTimer := newWarmupTracker(time.Now())
+	discardUntil := time.Now().Add(*startupDiscard)
+	discarding := *startupDiscard > 0
 
 	for {
 		log.Println("Attempting to read.")
-		pms, err := readPMS(port)
-		if err != nil {
+		pms, err := r.readPMS()
+		if errors.Is(err, errChecksum) {
 			log.Printf("readPMS: %v\n", err)
+			consecutiveValid.Store(0)
+			checksumErrors++
+			if *maxConsecutiveChecksumErrors > 0 && checksumErrors >= *maxConsecutiveChecksumErrors {
+				pms_desync_recoveries_total.Inc()
+				return fmt.Errorf("%d consecutive checksum errors, resyncing", checksumErrors)
+			}
 			continue
 		}
-		log.Printf("pms = %+v\n", pms)
+		if errors.Is(err, errCommandReply) {
+			log.Printf("readPMS: skipping %v\n", err)
+			continue
+		}
+		// Checksum errors and command replies just skip a frame. Anything
+		// else, like errClosed or errShortRead, needs the port reopened.
+		if err != nil {
+			return fmt.Errorf("readPMS: %w", err)
+		}
+		checksumErrors = 0
+		pms_frame_length.Set(float64(pms.Length))
 		if !pms.valid() {
-			log.Println("pms is not valid. Ignoring...")
+			log.Printf("pms is not valid: %+v. Ignoring...\n", pms)
+			pms_invalid_length_frames_total.Inc()
+			continue
+		}
+		// Real checksums include the magic so are never 0, which the zero
+		// previous has.
+		if pms.Checksum == previous.Checksum {
+			streak++
+		} else {
+			streak = 0
+		}
+		pms_checksum_unchanged_streak.Set(float64(streak))
+		// PMS5003 decodes every byte of the frame, so equal structs mean
+		// byte-identical frames.
+		if *dedupFrames && *pms == previous {
+			log.Println("Dropping duplicate frame.")
+			pms_duplicate_frames_total.Inc()
+			continue
+		}
+		previous = *pms
+		sequence++
+		log.Printf("pms #%d = %+v\n", sequence, pms)
+		n := consecutiveValid.Add(1)
+		// In passive mode the poller counts runtime itself, as it knows when
+		// the sensor is awake.
+		if *mode == "active" {
+			now := time.Now()
+			if gap := now.Sub(lastValid); gap < maxStreamingGap {
+				addRuntime(gap)
+			}
+			lastValid = now
+		}
+		warmupTimer.observe(pms, time.Now())
+		if warmup > 0 {
+			warmup--
+			log.Printf("Discarding warmup frame, %d to go.\n", warmup)
 			continue
 		}
-		pms_received_packets.Inc()
-		pms_particulate_matter_standard.WithLabelValues("1").Set(float64(pms.Pm10Std))
-		pms_particulate_matter_standard.WithLabelValues("2.5").Set(float64(pms.Pm25Std))
-		pms_particulate_matter_standard.WithLabelValues("10").Set(float64(pms.Pm100Std))
-		pms_particulate_matter_environmental.WithLabelValues("1").Set(float64(pms.Pm10Env))
-		pms_particulate_matter_environmental.WithLabelValues("2.5").Set(float64(pms.Pm25Env))
-		pms_particulate_matter_environmental.WithLabelValues("10").Set(float64(pms.Pm100Env))
-		pms_particle_counts.WithLabelValues("3").Set(float64(pms.Particles3um))
-		pms_particle_counts.WithLabelValues("5").Set(float64(pms.Particles5um))
-		pms_particle_counts.WithLabelValues("10").Set(float64(pms.Particles10um))
-		pms_particle_counts.WithLabelValues("25").Set(float64(pms.Particles25um))
-		pms_particle_counts.WithLabelValues("50").Set(float64(pms.Particles50um))
-		pms_particle_counts.WithLabelValues("100").Set(float64(pms.Particles100um))
+		if discarding {
+			if time.Now().Before(discardUntil) {
+				log.Println("Discarding frame during startup window.")
+				continue
+			}
+			log.Println("Startup discard window ended.")
+			discarding = false
+		}
+		if gated {
+			if n < *minConsecutiveValid {
+				log.Printf("Discarding frame: %d of %d consecutive valid frames.\n", n, *minConsecutiveValid)
+				continue
+			}
+			gated = false
+		}
+		frames.push(frame{pms, sequence})
+		time.Sleep(pace)
+	}
+}
+
+// countingReader counts bytes read from r in pms_serial_bytes_read_total.
+type countingReader struct {
+	r io.Reader
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	pms_serial_bytes_read_total.Add(float64(n))
+	return n, err
+}
+
+// updateMetrics exports a valid packet, which is frame number seq, to
+// prometheus.
+func updateMetrics(pms *PMS5003, seq uint64) {
+	if maintenance.Load() {
+		maintenanceFrameTime.Store(time.Now().UnixNano())
+		return
+	}
+	configMu.RLock()
+	defer configMu.RUnlock()
+	if !pms.plausible(uint16(*maxPlausiblePM)) {
+		log.Printf("pms has implausible PM values (max %d). Ignoring...\n", *maxPlausiblePM)
+		pms_implausible_frames_total.Inc()
+		checkByteSwapped(pms, uint16(*maxPlausiblePM))
+		return
+	}
+	if !pms.consistent() {
+		pms_inconsistent_frames_total.Inc()
+		if *skipInconsistent {
+			log.Printf("pms has environmental PM values above standard ones: %+v. Ignoring...\n", pms)
+			return
+		}
+	}
+	if !pms.cumulative() {
+		pms_noncumulative_frames_total.Inc()
+		if *skipNoncumulative {
+			log.Printf("pms has particle counts increasing with size: %+v. Ignoring...\n", pms)
+			return
+		}
+	}
+	pms_received_packets.Inc()
+	if *medianFilterEnabled {
+		pms = median.apply(pms)
+	}
+	if *countSaturation > 0 {
+		suspect := false
+		for _, c := range pms.counts() {
+			if uint(c.count) >= *countSaturation {
+				pms_count_saturation_total.WithLabelValues(countLabel(c.microns)).Inc()
+				suspect = true
+			}
+		}
+		if suspect {
+			log.Printf("pms has saturated particle counts: %+v\n", pms)
+			pms_reading_suspect.Set(1)
+		} else {
+			pms_reading_suspect.Set(0)
+		}
+	}
+	if previousPM25 >= 0 {
+		pms_pm25_delta.Set(float64(pms.Pm25Env) - float64(previousPM25))
+	}
+	previousPM25 = int(pms.Pm25Env)
+	now := time.Now()
+	setGauges(pms, now)
+	history.add(pms, now)
+	setLatest(pms, now, seq)
+	publishToSinks(pms, now, seq)
+	if nowcastPM25 != nil && pms.Pm25Env != saturatedPM {
+		if c, ok := nowcastPM25.add(float64(pms.Pm25Env), now); ok {
+			pms_nowcast_pm25.Set(c)
+			pms_nowcast_aqi.Set(aqi(c, pm25Breakpoints))
+		}
+	}
+	if a := alertAQI(pms); *aqiHistogram && !math.IsNaN(a) {
+		pms_aqi_histogram.Observe(a)
+	}
+	sessionReadings.Add(1)
+	framesSinceScrape.Add(1)
+	if *stateFile != "" {
+		throttledSaveState(*stateFile, *stateInterval, pms, now)
+	}
+}
+
+// setGauges sets the reading gauges from pms, which was read at time t.
+func setGauges(pms *PMS5003, t time.Time) {
+	pms_last_reading_timestamp_seconds.Set(float64(t.UnixNano()) / 1e9)
+	pms_particulate_matter_standard.WithLabelValues(pmLabel("1")).Set(float64(pms.Pm10Std))
+	pms_particulate_matter_standard.WithLabelValues(pmLabel("2.5")).Set(float64(pms.Pm25Std))
+	pms_particulate_matter_standard.WithLabelValues(pmLabel("10")).Set(float64(pms.Pm100Std))
+	pms_particulate_matter_environmental.WithLabelValues(pmLabel("1")).Set(float64(pms.Pm10Env))
+	pms_particulate_matter_environmental.WithLabelValues(pmLabel("2.5")).Set(float64(pms.Pm25Env))
+	pms_particulate_matter_environmental.WithLabelValues(pmLabel("10")).Set(float64(pms.Pm100Env))
+	pms_particulate_matter.WithLabelValues("standard", pmLabel("1")).Set(float64(pms.Pm10Std))
+	pms_particulate_matter.WithLabelValues("standard", pmLabel("2.5")).Set(float64(pms.Pm25Std))
+	pms_particulate_matter.WithLabelValues("standard", pmLabel("10")).Set(float64(pms.Pm100Std))
+	pms_particulate_matter.WithLabelValues("environmental", pmLabel("1")).Set(float64(pms.Pm10Env))
+	pms_particulate_matter.WithLabelValues("environmental", pmLabel("2.5")).Set(float64(pms.Pm25Env))
+	pms_particulate_matter.WithLabelValues("environmental", pmLabel("10")).Set(float64(pms.Pm100Env))
+	for _, r := range []struct {
+		microns  string
+		std, env uint16
+	}{
+		{"1", pms.Pm10Std, pms.Pm10Env},
+		{"2.5", pms.Pm25Std, pms.Pm25Env},
+		{"10", pms.Pm100Std, pms.Pm100Env},
+	} {
+		if ratio, ok := envStdRatio(r.std, r.env); ok {
+			pms_env_std_ratio.WithLabelValues(pmLabel(r.microns)).Set(ratio)
+		} else {
+			pms_env_std_ratio.DeleteLabelValues(pmLabel(r.microns))
+		}
+	}
+	pms_aqi.WithLabelValues("pm25", "env").Set(pm25AQI(pms.Pm25Env))
+	pms_aqi.WithLabelValues("pm25", "std").Set(pm25AQI(pms.Pm25Std))
+	pms_aqi.WithLabelValues("pm10", "env").Set(pm10AQI(pms.Pm100Env))
+	pms_aqi.WithLabelValues("pm10", "std").Set(pm10AQI(pms.Pm100Std))
+	pms_pm25.Set(pmFromSource(pms.Pm25Std, pms.Pm25Env))
+	pms_pm10.Set(pmFromSource(pms.Pm100Std, pms.Pm100Env))
+	if *applyCalibration && calibration != nil {
+		if c, ok := calibration.apply(pmFromSource(pms.Pm25Std, pms.Pm25Env)); ok {
+			pms_pm25_calibrated.Set(c)
+		}
+	}
+	if rh, ok := latestHumidity(); ok {
+		pms_pm25_humidity_corrected.Set(epaCorrectedPM25(pms.Pm25Std, rh))
+	}
+	pms_sensor_version.Set(float64(pms.Version))
+	pms_sensor_error_code.Set(float64(pms.ErrorCode))
+	restoreReadingSeries()
+	if pms.Length == pms3003Length {
+		// The PMS3003 doesn't count particles.
+		return
+	}
+	for _, c := range pms.counts() {
+		pms_particle_counts.WithLabelValues(countLabel(c.microns)).Set(float64(c.count))
+	}
+}
+
+// envStdRatio returns env/std, or false if std is 0 and there's no ratio.
+func envStdRatio(std, env uint16) (float64, bool) {
+	if std == 0 {
+		return 0, false
+	}
+	return float64(env) / float64(std), true
+}
+
+// pmFromSource picks between a standard and environmental value as -pm-source
+// says.
+func pmFromSource(std, env uint16) float64 {
+	switch *pmSource {
+	case "standard":
+		return float64(std)
+	case "average":
+		return (float64(std) + float64(env)) / 2
+	}
+	return float64(env)
+}
+
+// epaCorrectedPM25 applies the US EPA's US-wide correction for PMS5003-based
+// sensors to a CF=1 (standard) PM2.5 reading, given relative humidity in
+// percent.
+func epaCorrectedPM25(pm25 uint16, rh float64) float64 {
+	c := 0.524*float64(pm25) - 0.0862*rh + 5.75
+	if c < 0 {
+		return 0
 	}
+	return c
 }
 
 // PMS5003 wraps an air quality packet, as documented in https://cdn-shop.adafruit.com/product-files/3686/plantower-pms5003-manual_v2-3.pdf
@@ -161,73 +1210,253 @@ type PMS5003 struct {
 	Particles25um  uint16
 	Particles50um  uint16
 	Particles100um uint16
-	Unused         uint16
-	Checksum       uint16
+	// Version and ErrorCode fill the datasheet's "reserved" word. Splitting
+	// it into bytes keeps every other field at the same offset.
+	Version   uint8
+	ErrorCode uint8
+	Checksum  uint16
+}
+
+// measurements returns pointers to every concentration and count field.
+func (p *PMS5003) measurements() []*uint16 {
+	return []*uint16{
+		&p.Pm10Std, &p.Pm25Std, &p.Pm100Std,
+		&p.Pm10Env, &p.Pm25Env, &p.Pm100Env,
+		&p.Particles3um, &p.Particles5um, &p.Particles10um,
+		&p.Particles25um, &p.Particles50um, &p.Particles100um,
+	}
+}
+
+// particleCount is one of the cumulative particle count fields, labelled by
+// its lower bound in tenths of a micron.
+type particleCount struct {
+	microns string
+	count   uint16
+}
+
+// counts returns the particle counts in order of increasing size.
+func (p *PMS5003) counts() []particleCount {
+	return []particleCount{
+		{"3", p.Particles3um},
+		{"5", p.Particles5um},
+		{"10", p.Particles10um},
+		{"25", p.Particles25um},
+		{"50", p.Particles50um},
+		{"100", p.Particles100um},
+	}
 }
 
 func (p *PMS5003) valid() bool {
+	switch sensorModel() {
+	case "pms3003":
+		return (&PMS3003{Length: p.Length}).valid()
+	case "auto":
+		// Either, until -sensor=auto settles on one.
+		return p.Length == 28 || p.Length == pms3003Length
+	}
 	if p.Length != 28 {
 		return false
 	}
 	return true
 }
 
-func readPMS(r io.Reader) (*PMS5003, error) {
-	if err := awaitMagic(r); err != nil {
-		// Read errors are likely unrecoverable - just quit and restart.
-		log.Fatalf("awaitMagic: %v", err)
+// plausible reports whether every PM value is at most max. A checksum can pass
+// while the data is garbage, so this is a second line of defense.
+func (p *PMS5003) plausible(max uint16) bool {
+	if max == 0 {
+		return true
 	}
-	buf := make([]byte, 30)
-	n, err := io.ReadFull(r, buf)
-	if err != nil {
-		// Read errors are likely unrecoverable - just quit and restart.
-		log.Fatalf("ReadFull: %v", err)
+	for _, v := range []uint16{p.Pm10Std, p.Pm25Std, p.Pm100Std, p.Pm10Env, p.Pm25Env, p.Pm100Env} {
+		if v > max {
+			return false
+		}
+	}
+	return true
+}
+
+// consistent reports whether the standard and environmental PM values agree.
+// The sensor derives environmental values from standard ones, leaving low
+// concentrations unchanged and scaling higher ones down, so an environmental
+// value above its standard counterpart means the frame is corrupt.
+func (p *PMS5003) consistent() bool {
+	return p.Pm10Env <= p.Pm10Std && p.Pm25Env <= p.Pm25Std && p.Pm100Env <= p.Pm100Std
+}
+
+// cumulative reports whether the particle counts never increase with size.
+// Each counts every particle at or above its size, so one that does means the
+// frame is corrupt.
+func (p *PMS5003) cumulative() bool {
+	counts := p.counts()
+	for i := 1; i < len(counts); i++ {
+		if counts[i].count > counts[i-1].count {
+			return false
+		}
+	}
+	return true
+}
+
+// pmsReader reads frames from r, reusing one buffer so the hot path doesn't
+// allocate per byte or per frame.
+type pmsReader struct {
+	r              io.Reader
+	magic1, magic2 byte
+	// pms3003 reads the PMS3003's shorter frames, for -sensor=pms3003.
+	pms3003 bool
+	// detect is set while -sensor=auto is still working out the model,
+	// during which each frame is read as its length field says.
+	detect *sensorDetector
+	// passive is set in -mode=passive, where the sensor only sends frames
+	// and command replies we asked for, so any other byte is unexpected.
+	passive bool
+	// skipped counts bytes the last awaitMagic skipped to find the magic.
+	skipped int
+	buf     [30]byte
+}
+
+func newPMSReader(r io.Reader) *pmsReader {
+	m1, m2 := magic()
+	pr := &pmsReader{r: r, magic1: m1, magic2: m2, pms3003: sensorModel() == "pms3003", passive: *mode == "passive"}
+	if sensorModel() == "auto" {
+		pr.detect = &sensorDetector{}
 	}
-	if n != 30 {
-		return nil, fmt.Errorf("too few bytes read: want %d got %d", 30, n)
+	return pr
+}
+
+// setModeInfo sets pms_mode_info, reporting the sensor as model.
+func setModeInfo(model string) {
+	if *mode == "passive" {
+		pms_mode_info.WithLabelValues(*mode, model, passiveWarmup.String(), strconv.Itoa(*passiveFrames)).Set(1)
+	} else {
+		pms_mode_info.WithLabelValues(*mode, model, "", "").Set(1)
 	}
+}
 
-	var sum uint16 = uint16(magic1) + uint16(magic2)
-	for i := 0; i < 28; i++ {
-		sum += uint16(buf[i])
+// magic returns the start bytes given by -magic1 and -magic2.
+func magic() (byte, byte) {
+	return byte(*magic1), byte(*magic2)
+}
+
+func (pr *pmsReader) readPMS() (*PMS5003, error) {
+	r := pr.r
+	start := time.Now()
+	err := pr.awaitMagic()
+	pms_resync_duration_seconds.Observe(time.Since(start).Seconds())
+	if err != nil {
+		// Read errors are likely unrecoverable - the caller should reopen the port.
+		return nil, readError("awaitMagic", err)
+	}
+	buf := pr.buf[:]
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return nil, readError("ReadFull", midFrame(err))
+	}
+	if binary.BigEndian.Uint16(buf) == commandReplyLength {
+		// Skip the reply, lest we read the start of the next frame as its body.
+		if _, err := io.ReadFull(r, buf[2:2+commandReplyLength]); err != nil {
+			return nil, readError("ReadFull", midFrame(err))
+		}
+		return nil, fmt.Errorf("%w: %x", errCommandReply, buf[:2+commandReplyLength])
+	}
+	if pr.detect != nil {
+		pr.pms3003 = binary.BigEndian.Uint16(buf) == pms3003Length
+	}
+	if pr.pms3003 {
+		buf = buf[:2+pms3003Length]
+	}
+	if _, err := io.ReadFull(r, buf[2:]); err != nil {
+		// Read errors are likely unrecoverable - the caller should reopen the port.
+		return nil, readError("ReadFull", midFrame(err))
 	}
 
-	var p PMS5003
-	bufR := bytes.NewReader(buf)
-	binary.Read(bufR, binary.BigEndian, &p)
+	var p *PMS5003
+	if pr.pms3003 {
+		var p3 PMS3003
+		decodePMS3003(buf, &p3)
+		p = p3.pms5003()
+	} else {
+		p = new(PMS5003)
+		decodePMS(buf, p)
+	}
 
-	if sum != p.Checksum {
+	if !verifyChecksum(pr.magic1, pr.magic2, buf[:len(buf)-2], p.Checksum) {
 		// This error is recoverable
 		pms_packet_checksum_errors.Inc()
-		return nil, fmt.Errorf("checksum: got %v want %v", sum, p)
+		if pr.skipped > 0 {
+			// We were out of sync, so this "magic" was likely just data.
+			pms_false_sync_total.Inc()
+		}
+		if *ignoreChecksum {
+			log.Printf("checksum mismatch in %+v, using it anyway\n", *p)
+			return p, nil
+		}
+		return nil, fmt.Errorf("%w: want %v", errChecksum, *p)
+	}
+	if pr.detect != nil {
+		if model := pr.detect.observe(p); model != "" {
+			lockSensor(model)
+			pr.detect = nil
+			pr.pms3003 = model == "pms3003"
+		}
+	}
+	return p, nil
+}
+
+// decodePMS decodes the 30 bytes following the magic into p. It does by hand
+// what binary.Read would, without binary.Read's allocations.
+func decodePMS(buf []byte, p *PMS5003) {
+	be := binary.BigEndian
+	p.Length = be.Uint16(buf[0:])
+	for i, m := range [...]*uint16{
+		&p.Pm10Std, &p.Pm25Std, &p.Pm100Std,
+		&p.Pm10Env, &p.Pm25Env, &p.Pm100Env,
+		&p.Particles3um, &p.Particles5um, &p.Particles10um,
+		&p.Particles25um, &p.Particles50um, &p.Particles100um,
+	} {
+		*m = be.Uint16(buf[2+2*i:])
+	}
+	p.Version = buf[26]
+	p.ErrorCode = buf[27]
+	p.Checksum = be.Uint16(buf[28:])
+}
+
+// verifyChecksum reports whether want is the sum of the two magic bytes and
+// every payload byte, which is how Plantower sensors checksum their frames.
+func verifyChecksum(magic1, magic2 byte, payload []byte, want uint16) bool {
+	sum := uint16(magic1) + uint16(magic2)
+	for _, b := range payload {
+		sum += uint16(b)
 	}
-	return &p, nil
+	return sum == want
 }
 
-func awaitMagic(r io.Reader) error {
+func (pr *pmsReader) awaitMagic() error {
 	log.Println("Awaiting magic... ")
+	pr.skipped = 0
 	var b1 byte
-	b2, err := pop(r)
+	b2, err := pr.pop()
 	if err != nil {
 		return err
 	}
 	for {
 		b1 = b2
-		b2, err = pop(r)
+		b2, err = pr.pop()
 		if err != nil {
 			return err
 		}
-		if b1 == magic1 && b2 == magic2 {
+		if b1 == pr.magic1 && b2 == pr.magic2 {
 			// found magic
 			return nil
 		}
 		pms_skipped_bytes.Inc()
+		pr.skipped++
+		if pr.passive {
+			pms_passive_unexpected_bytes_total.Inc()
+		}
 	}
 }
 
-func pop(r io.Reader) (byte, error) {
-	b := make([]byte, 1)
-	_, err := r.Read(b)
+func (pr *pmsReader) pop() (byte, error) {
+	b := pr.buf[:1]
+	_, err := io.ReadFull(pr.r, b)
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"math/bits"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pms_byte_swapped_frames_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_byte_swapped_frames_total",
+			Help: "Implausible frames whose PM values become plausible with their bytes swapped, suggesting a sensor sending little-endian fields",
+		},
+	)
+
+	byteSwapWarning sync.Once
+)
+
+// byteSwapped returns p with the bytes of each PM value swapped, as they'd
+// have decoded from a sensor sending little-endian fields. The byte sum
+// checksum can't tell the two apart.
+func (p *PMS5003) byteSwapped() *PMS5003 {
+	s := *p
+	for _, v := range []*uint16{&s.Pm10Std, &s.Pm25Std, &s.Pm100Std, &s.Pm10Env, &s.Pm25Env, &s.Pm100Env} {
+		*v = bits.ReverseBytes16(*v)
+	}
+	return &s
+}
+
+// checkByteSwapped counts an implausible frame that looks byte-swapped,
+// warning about the first.
+func checkByteSwapped(pms *PMS5003, max uint16) {
+	s := pms.byteSwapped()
+	if !s.plausible(max) || !s.consistent() {
+		return
+	}
+	pms_byte_swapped_frames_total.Inc()
+	byteSwapWarning.Do(func() {
+		log.Printf("WARNING: implausible frame %+v is plausible with its bytes swapped: %+v. The sensor may be a clone sending little-endian fields, while breathe reads them big-endian, as the datasheet specifies.\n", *pms, *s)
+	})
+}
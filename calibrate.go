@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// calibrationMinSamples is how many pairs a fit needs before it's
+	// reported or applied.
+	calibrationMinSamples = 10
+	// calibrationMaxAge is how old the latest reading may be to pair it with
+	// a reference value.
+	calibrationMaxAge = time.Minute
+)
+
+var (
+	pms_calibration_samples = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_calibration_samples",
+			Help: "Pairs of sensor and reference PM2.5 posted to /calibrate/observe (requires -calibrate)",
+		},
+	)
+
+	pms_calibration_slope = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_calibration_slope",
+			Help: "Slope of the least-squares fit of reference PM2.5 to the sensor's (requires -calibrate)",
+		},
+	)
+
+	pms_calibration_intercept = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_calibration_intercept",
+			Help: "Intercept of the least-squares fit of reference PM2.5 to the sensor's, in micrograms per cubic meter (requires -calibrate)",
+		},
+	)
+
+	pms_calibration_r_squared = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_calibration_r_squared",
+			Help: "Coefficient of determination of the calibration fit: 1 if the sensor tracks the reference perfectly (requires -calibrate)",
+		},
+	)
+
+	pms_pm25_calibrated = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_pm25_calibrated",
+			Help: "pms_pm25 corrected by the calibration fit, in micrograms per cubic meter (requires -apply-calibration)",
+		},
+	)
+
+	// calibration accumulates /calibrate/observe pairs when -calibrate is set.
+	calibration *calibrationFit
+)
+
+// calibrationFit fits reference = slope*sensor + intercept by least squares.
+// It keeps running sums rather than the samples, so it never grows.
+type calibrationFit struct {
+	mu                              sync.Mutex
+	n                               int
+	sumX, sumY, sumXX, sumXY, sumYY float64
+}
+
+// calibrationResult is the fit served by /calibrate/fit.
+type calibrationResult struct {
+	Samples   int
+	Slope     float64
+	Intercept float64
+	RSquared  float64
+}
+
+// add records a pair of sensor and reference values.
+func (c *calibrationFit) add(sensor, reference float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n++
+	c.sumX += sensor
+	c.sumY += reference
+	c.sumXX += sensor * sensor
+	c.sumXY += sensor * reference
+	c.sumYY += reference * reference
+}
+
+func (c *calibrationFit) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n = 0
+	c.sumX, c.sumY, c.sumXX, c.sumXY, c.sumYY = 0, 0, 0, 0, 0
+}
+
+// fit returns the fit, or false if there are too few samples or the sensor
+// values are all the same.
+func (c *calibrationFit) fit() (calibrationResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := calibrationResult{Samples: c.n}
+	n := float64(c.n)
+	varX := n*c.sumXX - c.sumX*c.sumX
+	if c.n < calibrationMinSamples || varX == 0 {
+		return f, false
+	}
+	covXY := n*c.sumXY - c.sumX*c.sumY
+	f.Slope = covXY / varX
+	f.Intercept = (c.sumY - f.Slope*c.sumX) / n
+	if varY := n*c.sumYY - c.sumY*c.sumY; varY > 0 {
+		f.RSquared = covXY * covXY / (varX * varY)
+	} else {
+		// A constant reference is fit exactly.
+		f.RSquared = 1
+	}
+	return f, true
+}
+
+// apply corrects a PM2.5 value with the fit, or returns false without one.
+func (c *calibrationFit) apply(pm25 float64) (float64, bool) {
+	f, ok := c.fit()
+	if !ok {
+		return 0, false
+	}
+	return f.Slope*pm25 + f.Intercept, true
+}
+
+// serveCalibrateObserve pairs a POSTed reference PM2.5, the pm25 form
+// value, with the latest reading's pms_pm25. DELETE discards the samples.
+// Both need the -calibrate-token bearer token.
+func serveCalibrateObserve(w http.ResponseWriter, r *http.Request) {
+	if (r.Method == http.MethodPost || r.Method == http.MethodDelete) && !checkBearer(w, r, *calibrateToken) {
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		calibration.reset()
+		updateCalibrationMetrics()
+		log.Println("Calibration samples discarded.")
+		fmt.Fprintln(w, "samples: 0")
+		return
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	reference, err := strconv.ParseFloat(r.FormValue("pm25"), 64)
+	if err != nil || reference < 0 || math.IsInf(reference, 0) {
+		http.Error(w, fmt.Sprintf("pm25: want a non-negative number, got %q", r.FormValue("pm25")), http.StatusBadRequest)
+		return
+	}
+	latestMu.Lock()
+	l := latest
+	latestMu.Unlock()
+	if l == nil || time.Since(l.Time) > calibrationMaxAge {
+		http.Error(w, fmt.Sprintf("no reading in the last %v to pair with", calibrationMaxAge), http.StatusServiceUnavailable)
+		return
+	}
+	sensor := pmFromSource(l.Reading.Pm25Std, l.Reading.Pm25Env)
+	calibration.add(sensor, reference)
+	updateCalibrationMetrics()
+	f, _ := calibration.fit()
+	fmt.Fprintf(w, "sensor: %v\nreference: %v\nsamples: %d\n", sensor, reference, f.Samples)
+}
+
+// serveCalibrateFit serves the fit as JSON, or 404 if there isn't one yet.
+func serveCalibrateFit(w http.ResponseWriter, r *http.Request) {
+	f, ok := calibration.fit()
+	if !ok {
+		http.Error(w, fmt.Sprintf("%d samples of varying PM2.5; need %d", f.Samples, calibrationMinSamples), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
+
+func updateCalibrationMetrics() {
+	f, ok := calibration.fit()
+	pms_calibration_samples.Set(float64(f.Samples))
+	if !ok {
+		f.Slope, f.Intercept, f.RSquared = math.NaN(), math.NaN(), math.NaN()
+	}
+	pms_calibration_slope.Set(f.Slope)
+	pms_calibration_intercept.Set(f.Intercept)
+	pms_calibration_r_squared.Set(f.RSquared)
+}
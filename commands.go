@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Commands from appendix B of the datasheet.
+const (
+	cmdChangeMode  = 0xe1
+	cmdReadPassive = 0xe2
+	cmdSleep       = 0xe4
+
+	modePassive = 0x00
+	modeActive  = 0x01
+
+	sleepSleep = 0x00
+	sleepWake  = 0x01
+
+	// commandReplyLength is the length field of the sensor's reply to the
+	// change mode and sleep commands.
+	commandReplyLength = 4
+)
+
+var pms_active_mode_reasserts_total = promauto.With(registry).NewCounter(
+	prometheus.CounterOpts{
+		Name: "pms_active_mode_reasserts_total",
+		Help: "Times the active mode command was re-sent by -reassert-active-interval",
+	},
+)
+
+// writeCommand sends a command frame: the magic bytes, the command, two data
+// bytes and a checksum of everything before it.
+func writeCommand(w io.Writer, cmd byte, data uint16) error {
+	m1, m2 := magic()
+	buf := []byte{m1, m2, cmd, byte(data >> 8), byte(data)}
+	var sum uint16
+	for _, b := range buf {
+		sum += uint16(b)
+	}
+	buf = binary.BigEndian.AppendUint16(buf, sum)
+	_, err := w.Write(buf)
+	return err
+}
+
+// reassertActiveMode re-sends the active mode command every interval until
+// done is closed, in case a power glitch reset the sensor to passive mode.
+func reassertActiveMode(w io.Writer, interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		if err := writeCommand(w, cmdChangeMode, modeActive); err != nil {
+			log.Printf("reassertActiveMode: %v\n", err)
+			continue
+		}
+		pms_active_mode_reasserts_total.Inc()
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sensitiveFlagWords mark flags whose values must never be served by /config.
+var sensitiveFlagWords = []string{"token", "password", "secret", "key"}
+
+func sensitiveFlag(name string) bool {
+	for _, w := range sensitiveFlagWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL hides the userinfo and query of a URL-valued flag, where
+// credentials and API keys usually go, so /config and the logs can show the
+// rest.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "REDACTED"
+	}
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "REDACTED"
+	}
+	return u.String()
+}
+
+// serveConfig serves the effective value of every flag as JSON.
+func serveConfig(w http.ResponseWriter, r *http.Request) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	config := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		if sensitiveFlag(f.Name) && v != "" {
+			v = "REDACTED"
+		} else if strings.HasSuffix(f.Name, "-url") && v != "" {
+			v = redactURL(v)
+		}
+		config[f.Name] = v
+	})
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(config)
+}
+
+// flagRule describes which other flags may or must accompany a flag when it's
+// set on the command line or in -config.
+type flagRule struct {
+	flag      string
+	conflicts []string
+	requires  []string
+}
+
+// flagRules lists incompatible and dependent flag combinations.
+var flagRules = []flagRule{
+	{flag: "bme280-addr", requires: []string{"bme280-i2c-bus"}},
+	{flag: "redis-stream", requires: []string{"redis-addr"}},
+	{flag: "graphite-prefix", requires: []string{"graphite-addr"}},
+	{flag: "statsd-prefix", requires: []string{"statsd-addr"}},
+	{flag: "change-threshold", requires: []string{"on-change-only"}},
+	{flag: "apply-calibration", requires: []string{"calibrate"}},
+	{flag: "calibrate", requires: []string{"calibrate-token"}},
+	{flag: "calibrate-token", requires: []string{"calibrate"}},
+	{flag: "change-heartbeat", requires: []string{"on-change-only"}},
+	{flag: "selftest-timeout", requires: []string{"selftest"}},
+	{flag: "selftest-fatal", requires: []string{"selftest"}},
+	{flag: "benchmark-frames", requires: []string{"benchmark"}},
+	{flag: "replay-csv", conflicts: []string{"portname", "mode"}},
+	{flag: "replay-speed", requires: []string{"replay-csv"}},
+	{flag: "push-job", requires: []string{"pushgateway-url"}},
+	{flag: "outdoor-interval", requires: []string{"outdoor-api-url"}},
+	{flag: "outdoor-pm25-field", requires: []string{"outdoor-api-url"}},
+	{flag: "outdoor-aqi-field", requires: []string{"outdoor-api-url"}},
+	{flag: "push-interval", requires: []string{"pushgateway-url"}},
+	{flag: "state-interval", requires: []string{"state-file"}},
+}
+
+// configFileFlags are the flags loadConfigFile set from -config, which
+// flag.Visit doesn't know about.
+var configFileFlags = map[string]bool{}
+
+// setFlags returns the flags given on the command line or in -config, whatever
+// their value: -selftest-fatal=false is as much a use of -selftest-fatal as
+// -selftest-fatal is.
+func setFlags() map[string]bool {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name := range configFileFlags {
+		set[name] = true
+	}
+	return set
+}
+
+func checkFlagRules(rules []flagRule, set map[string]bool) []error {
+	var errs []error
+	for _, r := range rules {
+		if !set[r.flag] {
+			continue
+		}
+		for _, c := range r.conflicts {
+			if set[c] {
+				errs = append(errs, fmt.Errorf("-%s: cannot be used with -%s", r.flag, c))
+			}
+		}
+		for _, q := range r.requires {
+			if !set[q] {
+				errs = append(errs, fmt.Errorf("-%s: requires -%s", r.flag, q))
+			}
+		}
+	}
+	return errs
+}
+
+// validateFlags checks the parsed flags, returning every problem found rather
+// than just the first.
+func validateFlags() error {
+	errs := checkFlagRules(flagRules, setFlags())
+	if _, _, err := net.SplitHostPort(*port); err != nil {
+		errs = append(errs, fmt.Errorf("-port: %w", err))
+	}
+	for _, f := range []struct{ name, addr string }{
+		{"metrics-listen", *metricsListen},
+		{"ui-listen", *uiListen},
+	} {
+		if f.addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(f.addr); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", f.name, err))
+		}
+	}
+	if *pathPrefix != "" && (!strings.HasPrefix(*pathPrefix, "/") || strings.HasSuffix(*pathPrefix, "/")) {
+		errs = append(errs, fmt.Errorf("-path-prefix: want a path like /breathe, starting but not ending with /, got %q", *pathPrefix))
+	}
+	if *maxReconnectsPerMinute < 1 {
+		errs = append(errs, fmt.Errorf("-max-reconnects-per-minute: must be at least 1, got %d", *maxReconnectsPerMinute))
+	}
+	if *reconnectCooldown < 0 {
+		errs = append(errs, fmt.Errorf("-reconnect-cooldown: must not be negative, got %v", *reconnectCooldown))
+	}
+	if *graphiteAddr != "" {
+		if _, _, err := net.SplitHostPort(*graphiteAddr); err != nil {
+			errs = append(errs, fmt.Errorf("-graphite-addr: %w", err))
+		}
+	}
+	if *changeHeartbeat <= 0 {
+		errs = append(errs, fmt.Errorf("-change-heartbeat: must be positive, got %v", *changeHeartbeat))
+	}
+	if *statsdAddr != "" {
+		if _, port, err := net.SplitHostPort(*statsdAddr); err != nil {
+			errs = append(errs, fmt.Errorf("-statsd-addr: %w", err))
+		} else if _, err := net.LookupPort("udp", port); err != nil {
+			errs = append(errs, fmt.Errorf("-statsd-addr: %w", err))
+		}
+	}
+	if *redisAddr != "" {
+		if _, _, err := net.SplitHostPort(*redisAddr); err != nil {
+			errs = append(errs, fmt.Errorf("-redis-addr: %w", err))
+		}
+	}
+	if *pushgatewayURL != "" {
+		if u, err := url.Parse(*pushgatewayURL); err != nil {
+			errs = append(errs, fmt.Errorf("-pushgateway-url: %w", err))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("-pushgateway-url: want an http or https URL, got %q", redactURL(*pushgatewayURL)))
+		}
+	}
+	if *outdoorAPIURL != "" {
+		if u, err := url.Parse(*outdoorAPIURL); err != nil {
+			errs = append(errs, fmt.Errorf("-outdoor-api-url: %w", err))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("-outdoor-api-url: want an http or https URL, got %q", redactURL(*outdoorAPIURL)))
+		}
+		if *outdoorPM25Field == "" && *outdoorAQIField == "" {
+			errs = append(errs, errors.New("-outdoor-api-url: -outdoor-pm25-field and -outdoor-aqi-field are both empty, so there's nothing to fetch"))
+		}
+	}
+	if *outdoorInterval <= 0 {
+		errs = append(errs, fmt.Errorf("-outdoor-interval: must be positive, got %v", *outdoorInterval))
+	}
+	if *pushJob == "" {
+		errs = append(errs, errors.New("-push-job: must not be empty"))
+	}
+	if *pushInterval <= 0 {
+		errs = append(errs, fmt.Errorf("-push-interval: must be positive, got %v", *pushInterval))
+	}
+	if addr, ok := strings.CutPrefix(*protobufOutput, "tcp://"); ok {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("-protobuf-output: %w", err))
+		}
+	}
+	if *reassertActiveInterval < 0 {
+		errs = append(errs, fmt.Errorf("-reassert-active-interval: must not be negative, got %v", *reassertActiveInterval))
+	}
+	if *selftestTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("-selftest-timeout: must be positive, got %v", *selftestTimeout))
+	}
+	if *maxConsecutiveChecksumErrors < 0 {
+		errs = append(errs, fmt.Errorf("-max-consecutive-checksum-errors: must not be negative, got %d", *maxConsecutiveChecksumErrors))
+	}
+	switch *mode {
+	case "active":
+	case "passive":
+		if *reassertActiveInterval > 0 {
+			errs = append(errs, errors.New("-reassert-active-interval: only meaningful in -mode=active"))
+		}
+		if *portname == "-" {
+			errs = append(errs, errors.New("-mode=passive: can't send commands to stdin"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("-mode: want active or passive, got %q", *mode))
+	}
+	switch *alertPollutant {
+	case "pm25", "pm10", "max":
+	default:
+		errs = append(errs, fmt.Errorf("-alert-pollutant: want pm25, pm10 or max, got %q", *alertPollutant))
+	}
+	switch *pmSource {
+	case "standard", "environmental", "average":
+	default:
+		errs = append(errs, fmt.Errorf("-pm-source: want standard, environmental or average, got %q", *pmSource))
+	}
+	if *summaryInterval < 0 {
+		errs = append(errs, fmt.Errorf("-summary-interval: must not be negative, got %v", *summaryInterval))
+	}
+	if *startJitter < 0 {
+		errs = append(errs, fmt.Errorf("-start-jitter: must not be negative, got %v", *startJitter))
+	}
+	switch *sensor {
+	case "pms5003":
+	case "pms3003", "auto":
+		if *sensor == "pms3003" && *particleSizeHistogram {
+			errs = append(errs, errors.New("-particle-size-histogram: the PMS3003 doesn't count particles"))
+		}
+		// Its datasheet documents no commands; it only streams.
+		if *mode != "active" || *reassertActiveInterval > 0 {
+			errs = append(errs, fmt.Errorf("-sensor=%v: the PMS3003 doesn't take commands, so needs -mode=active and no -reassert-active-interval", *sensor))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("-sensor: want pms5003, pms3003 or auto, got %q", *sensor))
+	}
+	if _, ok := labelSchemes[*labelCompat]; !ok {
+		errs = append(errs, fmt.Errorf("-label-compat: want one of %s, got %q", labelSchemeNames(), *labelCompat))
+	}
+	if *passiveWarmup < 0 {
+		errs = append(errs, fmt.Errorf("-passive-warmup: must not be negative, got %v", *passiveWarmup))
+	}
+	if *scrapeReadTimeout < 0 {
+		errs = append(errs, fmt.Errorf("-scrape-read-timeout: must not be negative, got %v", *scrapeReadTimeout))
+	}
+	if *passiveFrames < 1 {
+		errs = append(errs, fmt.Errorf("-passive-frames: must be at least 1, got %d", *passiveFrames))
+	}
+	if *laserRatedHours <= 0 {
+		errs = append(errs, fmt.Errorf("-laser-rated-hours: must be positive, got %v", *laserRatedHours))
+	}
+	if *replaySpeed <= 0 {
+		errs = append(errs, fmt.Errorf("-replay-speed: must be positive, got %v", *replaySpeed))
+	}
+	if *benchmarkFrames < 1 {
+		errs = append(errs, fmt.Errorf("-benchmark-frames: must be at least 1, got %d", *benchmarkFrames))
+	}
+	if *frameBuffer < 1 {
+		errs = append(errs, fmt.Errorf("-frame-buffer: must be at least 1, got %d", *frameBuffer))
+	}
+	if *warmupFrames < 0 {
+		errs = append(errs, fmt.Errorf("-warmup-frames: must not be negative, got %d", *warmupFrames))
+	}
+	if *stallTimeout < 0 {
+		errs = append(errs, fmt.Errorf("-stall-timeout: must not be negative, got %v", *stallTimeout))
+	}
+	if *slowThreshold < 0 {
+		errs = append(errs, fmt.Errorf("-slow-threshold: must not be negative, got %v", *slowThreshold))
+	}
+	if *deadThreshold < 0 {
+		errs = append(errs, fmt.Errorf("-dead-threshold: must not be negative, got %v", *deadThreshold))
+	}
+	if *slowThreshold > 0 && *deadThreshold > 0 && *slowThreshold >= *deadThreshold {
+		errs = append(errs, fmt.Errorf("-slow-threshold: must be less than -dead-threshold, got %v and %v", *slowThreshold, *deadThreshold))
+	}
+	if *mode == "passive" && (*slowThreshold > 0 || *deadThreshold > 0) {
+		errs = append(errs, errors.New("-slow-threshold, -dead-threshold: readings only arrive for scrapes in -mode=passive"))
+	}
+	if *staleOnTimeout && *stallTimeout == 0 {
+		errs = append(errs, fmt.Errorf("-stale-on-timeout: requires a nonzero -stall-timeout"))
+	}
+	if *startupDiscard < 0 {
+		errs = append(errs, fmt.Errorf("-startup-discard-duration: must not be negative, got %v", *startupDiscard))
+	}
+	if *minConsecutiveValid < 0 {
+		errs = append(errs, fmt.Errorf("-min-consecutive-valid: must not be negative, got %d", *minConsecutiveValid))
+	}
+	if *bme280Addr > 0x7f {
+		errs = append(errs, fmt.Errorf("-bme280-addr: not a 7-bit I2C address: %#x", *bme280Addr))
+	}
+	if *graphSamples < 0 {
+		errs = append(errs, fmt.Errorf("-graph-samples: must not be negative, got %d", *graphSamples))
+	}
+	if *countSaturation > 0xffff {
+		errs = append(errs, fmt.Errorf("-count-saturation-threshold: must fit in 16 bits, got %d", *countSaturation))
+	}
+	for _, f := range []struct {
+		name  string
+		value uint
+	}{{"magic1", *magic1}, {"magic2", *magic2}} {
+		if f.value > 0xff {
+			errs = append(errs, fmt.Errorf("-%s: must fit in a byte, got %#x", f.name, f.value))
+		}
+	}
+	if *maxPlausiblePM > 0xffff {
+		errs = append(errs, fmt.Errorf("-max-plausible-pm: must fit in 16 bits, got %d", *maxPlausiblePM))
+	}
+	if *indexTemplate != "" {
+		if _, err := template.ParseFiles(*indexTemplate); err != nil {
+			errs = append(errs, fmt.Errorf("-index-template: %w", err))
+		}
+	}
+	if *stateFile != "" {
+		if _, err := os.Stat(filepath.Dir(*stateFile)); err != nil {
+			errs = append(errs, fmt.Errorf("-state-file: %w", err))
+		}
+	}
+	if *stateInterval < 0 {
+		errs = append(errs, fmt.Errorf("-state-interval: must not be negative, got %v", *stateInterval))
+	}
+	return errors.Join(errs...)
+}
+
+// readConfigFile reads the YAML file at path, whose keys are flag names. It
+// omits flags given on the command line, which take precedence over the file.
+func readConfigFile(path string) (map[*flag.Flag]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	onCommandLine := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	config := map[*flag.Flag]string{}
+	var errs []error
+	for name, v := range values {
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			errs = append(errs, fmt.Errorf("%s: unknown key %q", path, name))
+			continue
+		}
+		if onCommandLine[name] {
+			continue
+		}
+		switch v.(type) {
+		case map[string]interface{}, []interface{}, nil:
+			errs = append(errs, fmt.Errorf("%s: %s: want a single value, got %v", path, name, v))
+			continue
+		}
+		config[f] = fmt.Sprint(v)
+	}
+	return config, errors.Join(errs...)
+}
+
+// loadConfigFile sets flags from the YAML file at path.
+func loadConfigFile(path string) error {
+	config, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for f, v := range config {
+		if err := f.Value.Set(v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, f.Name, err))
+			continue
+		}
+		configFileFlags[f.Name] = true
+	}
+	return errors.Join(errs...)
+}
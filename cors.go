@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsOrigins returns the origins allowed by -cors-origin.
+func corsOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(*corsOrigin, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// allowCORS lets browser pages from the given origins fetch h. An origin of
+// "*" allows any. Preflight requests are answered without calling h.
+func allowCORS(origins []string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		allowed := ""
+		for _, o := range origins {
+			if o == "*" || o == origin {
+				allowed = o
+				break
+			}
+		}
+		if origin == "" || allowed == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			if h := r.Header.Get("Access-Control-Request-Headers"); h != "" {
+				w.Header().Set("Access-Control-Allow-Headers", h)
+			}
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
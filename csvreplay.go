@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// csvReading is one row of a -replay-csv file.
+type csvReading struct {
+	t   time.Time
+	pms PMS5003
+}
+
+// parseTimestamp accepts RFC 3339 times or Unix seconds.
+func parseTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want an RFC 3339 time or Unix seconds, got %q", s)
+	}
+	return time.Unix(0, int64(secs*1e9)), nil
+}
+
+// readCSV reads readings from a CSV file whose header names its columns: a
+// timestamp column, plus any of the field names readingFields uses, such as
+// pm25_env. Missing fields are 0.
+func readCSV(r io.Reader) ([]csvReading, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("want a header and at least one reading, got %d rows", len(rows))
+	}
+	var fields PMS5003
+	names := map[string]bool{}
+	for _, f := range readingFields(&fields) {
+		names[f[0]] = true
+	}
+	header := rows[0]
+	timeCol := -1
+	for i, h := range header {
+		switch {
+		case h == "timestamp":
+			timeCol = i
+		case !names[h]:
+			return nil, fmt.Errorf("unknown column %q", h)
+		}
+	}
+	if timeCol < 0 {
+		return nil, fmt.Errorf("no timestamp column")
+	}
+	var readings []csvReading
+	for n, row := range rows[1:] {
+		line := n + 2
+		t, err := parseTimestamp(row[timeCol])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: timestamp: %w", line, err)
+		}
+		c := csvReading{t: t}
+		c.pms.Length = 28
+		for i, h := range header {
+			if i == timeCol {
+				continue
+			}
+			v, err := strconv.ParseUint(row[i], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %w", line, h, err)
+			}
+			*csvField(&c.pms, h) = uint16(v)
+		}
+		readings = append(readings, c)
+	}
+	return readings, nil
+}
+
+// csvField returns the field of p named as in readingFields.
+func csvField(p *PMS5003, name string) *uint16 {
+	return map[string]*uint16{
+		"pm1_std":         &p.Pm10Std,
+		"pm25_std":        &p.Pm25Std,
+		"pm10_std":        &p.Pm100Std,
+		"pm1_env":         &p.Pm10Env,
+		"pm25_env":        &p.Pm25Env,
+		"pm10_env":        &p.Pm100Env,
+		"particles_03um":  &p.Particles3um,
+		"particles_05um":  &p.Particles5um,
+		"particles_10um":  &p.Particles10um,
+		"particles_25um":  &p.Particles25um,
+		"particles_50um":  &p.Particles50um,
+		"particles_100um": &p.Particles100um,
+	}[name]
+}
+
+// replayCSV exports the readings in the CSV file at path, spacing them as
+// their timestamps were, sped up by speed. At the end it exits, or with
+// -loop starts again.
+func replayCSV(path string, speed float64) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("replayCSV: %v", err)
+	}
+	readings, err := readCSV(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("replayCSV: %s: %v", path, err)
+	}
+	portOpen.Store(true)
+	for {
+		for i := range readings {
+			if i > 0 {
+				gap := readings[i].t.Sub(readings[i-1].t)
+				time.Sleep(time.Duration(float64(gap) / speed))
+			}
+			sequence++
+			pms := readings[i].pms
+			updateMetrics(&pms, sequence)
+		}
+		if !*loop {
+			log.Println("End of -replay-csv.")
+			os.Exit(0)
+		}
+		log.Println("End of -replay-csv, replaying.")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+// medianFilter replaces each field with its median over the last three
+// readings, rejecting single-frame spikes without smoothing real changes.
+type medianFilter struct {
+	window [3]PMS5003
+	n      int
+}
+
+// apply adds p to the window and returns the filtered reading. Until the
+// window fills, p is returned unchanged.
+func (f *medianFilter) apply(p *PMS5003) *PMS5003 {
+	f.window[f.n%len(f.window)] = *p
+	f.n++
+	if f.n < len(f.window) {
+		return p
+	}
+	out := *p
+	a, b, c := f.window[0].measurements(), f.window[1].measurements(), f.window[2].measurements()
+	for i, v := range out.measurements() {
+		*v = median3(*a[i], *b[i], *c[i])
+	}
+	return &out
+}
+
+func median3(a, b, c uint16) uint16 {
+	if a > b {
+		a, b = b, a
+	}
+	if b > c {
+		b = c
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
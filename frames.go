@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var pms_frames_dropped_total = promauto.With(registry).NewCounter(
+	prometheus.CounterOpts{
+		Name: "pms_frames_dropped_total",
+		Help: "Valid frames dropped because the exporter fell behind the reader",
+	},
+)
+
+// frame is a valid packet awaiting export.
+type frame struct {
+	pms *PMS5003
+	seq uint64
+}
+
+// frameQueue decouples reading the serial port from exporting. It's bounded:
+// when full, the oldest frame is dropped so the reader never blocks and the
+// freshest data wins.
+type frameQueue chan frame
+
+func newFrameQueue(n int) frameQueue {
+	return make(frameQueue, n)
+}
+
+func (q frameQueue) push(f frame) {
+	for {
+		select {
+		case q <- f:
+			return
+		default:
+		}
+		select {
+		case <-q:
+			pms_frames_dropped_total.Inc()
+		default:
+		}
+	}
+}
+
+// exportFrames exports queued frames until q is closed.
+func exportFrames(q frameQueue) {
+	for f := range q {
+		updateMetrics(f.pms, f.seq)
+	}
+}
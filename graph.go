@@ -0,0 +1,46 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// graphPage plots the sample history with a hand-rolled canvas chart, so the
+// page works offline without any CDN.
+var graphPage = template.Must(template.New("graph").Parse(
+	`<!doctype html>
+	 <title>PMS5003 History</title>
+	 <h1>PMS5003 History</h1>
+	 <p>Environmental PM2.5 (blue) and PM10 (red), micrograms per cubic meter.
+	 <p><canvas id="c" width="800" height="300" style="border:1px solid #ccc"></canvas>
+	 <p id="range"></p>
+	 <script>
+	 const samples = {{.}} || [];
+	 const c = document.getElementById("c");
+	 const ctx = c.getContext("2d");
+	 if (samples.length > 1) {
+	   const t0 = samples[0].t, t1 = samples[samples.length - 1].t;
+	   let max = 1;
+	   for (const s of samples) max = Math.max(max, s.pm25, s.pm10);
+	   const x = t => (t - t0) / Math.max(1, t1 - t0) * (c.width - 40) + 30;
+	   const y = v => c.height - 10 - v / max * (c.height - 20);
+	   ctx.fillText(max, 2, 12);
+	   ctx.fillText(0, 2, c.height - 10);
+	   for (const [key, colour] of [["pm25", "blue"], ["pm10", "red"]]) {
+	     ctx.strokeStyle = colour;
+	     ctx.beginPath();
+	     samples.forEach((s, i) => i ? ctx.lineTo(x(s.t), y(s[key])) : ctx.moveTo(x(s.t), y(s[key])));
+	     ctx.stroke();
+	   }
+	   document.getElementById("range").textContent =
+	     new Date(t0).toLocaleString() + " to " + new Date(t1).toLocaleString();
+	 } else {
+	   document.getElementById("range").textContent = "No readings yet.";
+	 }
+	 </script>
+	 `))
+
+func serveGraph(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	graphPage.Execute(w, history.snapshot())
+}
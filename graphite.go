@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pms_graphite_errors_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_graphite_errors_total",
+			Help: "Failed sends to -graphite-addr",
+		},
+	)
+
+	pms_graphite_dropped_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_graphite_dropped_total",
+			Help: "Readings dropped because the Graphite queue was full",
+		},
+	)
+)
+
+// graphiteTimeout bounds each connection attempt and send.
+const graphiteTimeout = 5 * time.Second
+
+// graphiteLines formats a reading in Graphite's plaintext protocol, one
+// "<prefix>.pms.<field> <value> <unix time>" line per field.
+func graphiteLines(prefix string, pms *PMS5003, t time.Time) []byte {
+	if prefix != "" {
+		prefix += "."
+	}
+	var b bytes.Buffer
+	for _, f := range sinkFields(pms) {
+		fmt.Fprintf(&b, "%spms.%s %s %d\n", prefix, f[0], f[1], t.Unix())
+	}
+	return b.Bytes()
+}
+
+// newGraphiteSender returns a sink sending readings to the Carbon server at
+// addr.
+func newGraphiteSender(addr, prefix string) *queuedSink {
+	q := &queuedSink{
+		name:    "graphite",
+		timeout: graphiteTimeout,
+		errors:  pms_graphite_errors_total,
+		dropped: pms_graphite_dropped_total,
+		encode: func(pms *PMS5003, t time.Time, seq uint64) []byte {
+			return graphiteLines(prefix, pms, t)
+		},
+		dial: func() (io.WriteCloser, error) {
+			return net.DialTimeout("tcp", addr, graphiteTimeout)
+		},
+	}
+	return q.start()
+}
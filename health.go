@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+var (
+	// portOpen is true while the serial port is open.
+	portOpen atomic.Bool
+	// sessionStart is when the serial port was opened, in Unix nanoseconds,
+	// or 0 while it's closed.
+	sessionStart atomic.Int64
+	// sessionReadings counts valid packets exported since the port was opened.
+	sessionReadings atomic.Int64
+	// consecutiveValid counts valid frames since the last checksum error.
+	consecutiveValid atomic.Int64
+	// framesSinceScrape counts valid packets exported since /metrics was last
+	// served.
+	framesSinceScrape atomic.Int64
+)
+
+// serveHealthz reports that the process is alive, whatever the sensor's state.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// serveReadyz succeeds once the serial port is open and the sensor has
+// produced a valid packet, and at least -min-consecutive-valid frames since
+// the last checksum error, so orchestrators can wait out the fan warmup.
+func serveReadyz(w http.ResponseWriter, r *http.Request) {
+	if !portOpen.Load() {
+		http.Error(w, "serial port not open", http.StatusServiceUnavailable)
+		return
+	}
+	if sessionReadings.Load() == 0 {
+		http.Error(w, "no valid packets yet", http.StatusServiceUnavailable)
+		return
+	}
+	if n := consecutiveValid.Load(); n < *minConsecutiveValid {
+		http.Error(w, fmt.Sprintf("%d of %d consecutive valid frames", n, *minConsecutiveValid), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// sample is one reading kept for the /graph page.
+type sample struct {
+	Time  int64  `json:"t"` // Unix milliseconds
+	PM25  uint16 `json:"pm25"`
+	PM100 uint16 `json:"pm10"`
+}
+
+// sampleRing is a bounded, concurrency-safe buffer of the latest samples.
+type sampleRing struct {
+	mu      sync.Mutex
+	samples []sample
+	next    int
+	full    bool
+}
+
+func newSampleRing(n int) *sampleRing {
+	return &sampleRing{samples: make([]sample, n)}
+}
+
+func (r *sampleRing) add(pms *PMS5003, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.samples) == 0 {
+		return
+	}
+	r.samples[r.next] = sample{Time: t.UnixMilli(), PM25: pms.Pm25Env, PM100: pms.Pm100Env}
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// snapshot returns the buffered samples, oldest first.
+func (r *sampleRing) snapshot() []sample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]sample(nil), r.samples[:r.next]...)
+	}
+	return append(append([]sample(nil), r.samples[r.next:]...), r.samples[:r.next]...)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// i2cSlave is the I2C_SLAVE ioctl from linux/i2c-dev.h.
+const i2cSlave = 0x0703
+
+// i2cDevice is a single device on a Linux I2C bus.
+type i2cDevice struct {
+	f *os.File
+}
+
+func openI2C(bus string, addr uint16) (*i2cDevice, error) {
+	f, err := os.OpenFile(bus, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.IoctlSetInt(int(f.Fd()), i2cSlave, int(addr)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &i2cDevice{f: f}, nil
+}
+
+func (d *i2cDevice) readReg(reg byte, n int) ([]byte, error) {
+	if _, err := d.f.Write([]byte{reg}); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := d.f.Read(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (d *i2cDevice) writeReg(reg, v byte) error {
+	_, err := d.f.Write([]byte{reg, v})
+	return err
+}
+
+func (d *i2cDevice) Close() error {
+	return d.f.Close()
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// i2cDevice is unsupported outside Linux.
+type i2cDevice struct{}
+
+func openI2C(bus string, addr uint16) (*i2cDevice, error) {
+	return nil, errors.New("I2C is only supported on Linux")
+}
+
+func (d *i2cDevice) readReg(reg byte, n int) ([]byte, error) {
+	return nil, errors.New("I2C is only supported on Linux")
+}
+
+func (d *i2cDevice) writeReg(reg, v byte) error {
+	return errors.New("I2C is only supported on Linux")
+}
+
+func (d *i2cDevice) Close() error {
+	return nil
+}
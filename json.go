@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jsonReading is the body served by /json.
+type jsonReading struct {
+	// Sequence numbers valid frames since startup, so gaps reveal dropped frames.
+	Sequence uint64
+	Time     time.Time
+	Reading  PMS5003
+}
+
+var (
+	latestMu sync.Mutex
+	latest   *jsonReading
+)
+
+// setLatest records the reading served by /json.
+func setLatest(pms *PMS5003, t time.Time, seq uint64) {
+	latestMu.Lock()
+	defer latestMu.Unlock()
+	latest = &jsonReading{Sequence: seq, Time: t, Reading: *pms}
+}
+
+// serveJSON serves the latest reading, or 404 if there isn't one yet.
+func serveJSON(w http.ResponseWriter, r *http.Request) {
+	latestMu.Lock()
+	l := latest
+	latestMu.Unlock()
+	if l == nil {
+		http.Error(w, "no readings yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l)
+}
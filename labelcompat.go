@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// labelScheme renames the size label values, for -label-compat. pm maps the
+// microns label of the PM metrics, counts the microns_lower_bound label of
+// the particle counts, which is in tenths of a micron. Values missing from a
+// map keep breathe's own.
+type labelScheme struct {
+	pm, counts map[string]string
+}
+
+var labelSchemes = map[string]labelScheme{
+	"breathe": {},
+	// Decimal microns for both, as most exporters use.
+	"decimal": {
+		counts: map[string]string{"3": "0.3", "5": "0.5", "10": "1", "25": "2.5", "50": "5", "100": "10"},
+	},
+	// Identifier-safe names, like pm2_5.
+	"pm": {
+		pm:     map[string]string{"1": "pm1", "2.5": "pm2_5", "10": "pm10"},
+		counts: map[string]string{"3": "pm0_3", "5": "pm0_5", "10": "pm1", "25": "pm2_5", "50": "pm5", "100": "pm10"},
+	},
+}
+
+// labelSchemeNames lists the -label-compat schemes, for messages.
+func labelSchemeNames() string {
+	var names []string
+	for name := range labelSchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// pmLabel returns the microns label value for a PM size, e.g. "2.5".
+func pmLabel(microns string) string {
+	if v, ok := labelSchemes[*labelCompat].pm[microns]; ok {
+		return v
+	}
+	return microns
+}
+
+// countLabel returns the microns_lower_bound label value for a particle
+// count size in tenths of a micron, e.g. "25".
+func countLabel(microns string) string {
+	if v, ok := labelSchemes[*labelCompat].counts[microns]; ok {
+		return v
+	}
+	return microns
+}
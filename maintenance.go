@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// maintenance is set while the sensor is being serviced, and its readings
+	// shouldn't be exported. It's deliberately not persisted.
+	maintenance atomic.Bool
+	// maintenanceFrameTime is when a valid frame last arrived in maintenance
+	// mode, in Unix nanoseconds. updateMetrics drops those frames, but they
+	// show the sensor is alive.
+	maintenanceFrameTime atomic.Int64
+
+	pms_maintenance_mode = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_maintenance_mode",
+			Help: "1 while maintenance mode, set with POST /maintenance, stops readings being exported",
+		},
+	)
+)
+
+// serveMaintenance shows maintenance mode, or for a POST with the
+// -maintenance-token bearer token sets it. The enabled form value says
+// whether to enable it; without one the POST toggles it. Enabling it deletes
+// the reading series, so they go stale rather than flatlining.
+func serveMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if !checkBearer(w, r, *maintenanceToken) {
+			return
+		}
+		on := !maintenance.Load()
+		if v := r.FormValue("enabled"); v != "" {
+			var err error
+			if on, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, fmt.Sprintf("enabled: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		setMaintenance(on)
+	} else if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintf(w, "maintenance: %v\n", maintenance.Load())
+}
+
+// checkBearer reports whether r carries token as its bearer token, replying
+// 401 if it doesn't.
+func checkBearer(w http.ResponseWriter, r *http.Request, token string) bool {
+	want := "Bearer " + token
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// lastFrameTime returns when the sensor last sent a valid frame, exported or
+// dropped for maintenance mode, or the zero time if it hasn't. The watchdogs
+// go by this, so servicing the sensor doesn't look like it dying.
+func lastFrameTime() time.Time {
+	t := lastReadingTime()
+	if n := maintenanceFrameTime.Load(); n != 0 && time.Unix(0, n).After(t) {
+		return time.Unix(0, n)
+	}
+	return t
+}
+
+func setMaintenance(on bool) {
+	if maintenance.Swap(on) == on {
+		return
+	}
+	if on {
+		log.Println("Maintenance mode on: not exporting readings.")
+		pms_maintenance_mode.Set(1)
+		deleteReadingSeries()
+		return
+	}
+	log.Println("Maintenance mode off.")
+	pms_maintenance_mode.Set(0)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	pms_nowcast_pm25 = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_nowcast_pm25",
+			Help: "US EPA NowCast of environmental PM2.5 over the last 12 complete hours, in micrograms per cubic meter (requires -nowcast)",
+		},
+	)
+
+	pms_nowcast_aqi = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_nowcast_aqi",
+			Help: "US EPA AQI of pms_nowcast_pm25, as AirNow reports it (requires -nowcast)",
+		},
+	)
+)
+
+// nowcastHours is how many hourly averages NowCast weighs.
+const nowcastHours = 12
+
+// nowcast keeps hourly averages of PM2.5 for the EPA's NowCast, which weighs
+// recent hours more heavily the more the air has been changing:
+// https://usepa.servicenowservices.com/airnow?id=kb_article_view&sys_id=bb8b65ef1b06bc10028420eae54bcb98
+type nowcast struct {
+	mu sync.Mutex
+	// hour is the start of the hour being averaged.
+	hour     time.Time
+	sum      float64
+	n        int
+	averages [nowcastHours]float64 // most recent complete hour first; NaN if it had no readings
+}
+
+func newNowcast() *nowcast {
+	c := &nowcast{}
+	for i := range c.averages {
+		c.averages[i] = math.NaN()
+	}
+	return c
+}
+
+// add records a reading taken at t, and returns the NowCast concentration
+// over the complete hours before t, if there's enough data for one.
+func (c *nowcast) add(pm25 float64, t time.Time) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := t.Truncate(time.Hour)
+	if c.hour.IsZero() {
+		c.hour = h
+	}
+	if h.After(c.hour) {
+		avg := math.NaN()
+		if c.n > 0 {
+			avg = c.sum / float64(c.n)
+		}
+		for i := 0; i < int(h.Sub(c.hour)/time.Hour); i++ {
+			copy(c.averages[1:], c.averages[:nowcastHours-1])
+			c.averages[0] = avg
+			avg = math.NaN()
+		}
+		c.hour, c.sum, c.n = h, 0, 0
+	}
+	c.sum += pm25
+	c.n++
+	return nowcastConcentration(c.averages[:])
+}
+
+// nowcastConcentration returns the NowCast of hourly averages, most recent
+// first, truncated to 0.1ug/m3. There's no NowCast unless at least two of
+// the three most recent hours have data.
+func nowcastConcentration(averages []float64) (float64, bool) {
+	recent := 0
+	for _, a := range averages[:3] {
+		if !math.IsNaN(a) {
+			recent++
+		}
+	}
+	if recent < 2 {
+		return 0, false
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, a := range averages {
+		if !math.IsNaN(a) {
+			lo, hi = math.Min(lo, a), math.Max(hi, a)
+		}
+	}
+	w := 1.0
+	if hi > 0 {
+		w = math.Max(lo/hi, 0.5)
+	}
+	var num, den float64
+	for i, a := range averages {
+		if !math.IsNaN(a) {
+			weight := math.Pow(w, float64(i))
+			num += weight * a
+			den += weight
+		}
+	}
+	return math.Floor(num/den*10) / 10, true
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	pms_outdoor_pm25 = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_outdoor_pm25",
+			Help: "Outdoor PM2.5 in micrograms per cubic meter, from -outdoor-api-url",
+		},
+	)
+
+	pms_outdoor_aqi = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_outdoor_aqi",
+			Help: "Outdoor AQI, from -outdoor-api-url",
+		},
+	)
+
+	pms_outdoor_last_fetch_timestamp_seconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_outdoor_last_fetch_timestamp_seconds",
+			Help: "Unix time the pms_outdoor_* values were last fetched from -outdoor-api-url",
+		},
+	)
+
+	pms_outdoor_errors_total = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_outdoor_errors_total",
+			Help: "Failed fetches from -outdoor-api-url, including responses missing a field",
+		},
+	)
+)
+
+// outdoorTimeout bounds each fetch from -outdoor-api-url.
+const outdoorTimeout = 10 * time.Second
+
+// pollOutdoor fetches the outdoor values from url every interval. It's best
+// effort: a failed fetch leaves the gauges as they were until the next.
+func pollOutdoor(url string, interval time.Duration) {
+	client := &http.Client{Timeout: outdoorTimeout}
+	for ; ; time.Sleep(interval) {
+		if err := fetchOutdoor(client, url); err != nil {
+			log.Printf("outdoor: %v\n", err)
+			pms_outdoor_errors_total.Inc()
+		}
+	}
+}
+
+func fetchOutdoor(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	url = redactURL(url)
+	if err != nil {
+		// Unwrap the *url.Error, which quotes the URL with its query.
+		return fmt.Errorf("GET %v: %w", url, errors.Unwrap(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %v: %v", url, resp.Status)
+	}
+	var body any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return fmt.Errorf("decoding %v: %w", url, err)
+	}
+	var errs []string
+	for _, f := range []struct {
+		path  string
+		gauge prometheus.Gauge
+	}{
+		{*outdoorPM25Field, pms_outdoor_pm25},
+		{*outdoorAQIField, pms_outdoor_aqi},
+	} {
+		if f.path == "" {
+			continue
+		}
+		v, err := jsonNumber(body, f.path)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		f.gauge.Set(v)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v: %s", url, strings.Join(errs, "; "))
+	}
+	pms_outdoor_last_fetch_timestamp_seconds.Set(float64(time.Now().Unix()))
+	return nil
+}
+
+// jsonNumber follows a dotted path of object keys and array indexes, like
+// "data.0.pm25", through decoded JSON to a number. Numbers in strings are
+// accepted too, as some APIs send them that way.
+func jsonNumber(v any, path string) (float64, error) {
+	for _, key := range strings.Split(path, ".") {
+		switch x := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = x[key]; !ok {
+				return 0, fmt.Errorf("%s: no %q", path, key)
+			}
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(x) {
+				return 0, fmt.Errorf("%s: no element %q in an array of %d", path, key, len(x))
+			}
+			v = x[i]
+		default:
+			return 0, fmt.Errorf("%s: can't look up %q in %v", path, key, v)
+		}
+	}
+	switch x := v.(type) {
+	case float64:
+		return x, nil
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("%s: want a number, got %v", path, v)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var pms_serial_overruns_total = promauto.With(registry).NewCounter(
+	prometheus.CounterOpts{
+		Name: "pms_serial_overruns_total",
+		Help: "Bytes the serial driver dropped because we didn't read them in time. Only supported on Linux, and not by every driver",
+	},
+)
+
+// overrunInterval is how often watchOverruns polls the driver.
+const overrunInterval = 10 * time.Second
+
+// watchOverruns adds the driver's overrun count for port to
+// pms_serial_overruns_total until done is closed. It gives up quietly where
+// the count isn't available.
+func watchOverruns(port io.ReadWriteCloser, done <-chan struct{}) {
+	last, err := serialOverruns(port)
+	if err != nil {
+		log.Printf("Serial overruns unavailable: %v\n", err)
+		return
+	}
+	t := time.NewTicker(overrunInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		n, err := serialOverruns(port)
+		if err != nil {
+			log.Printf("serialOverruns: %v\n", err)
+			return
+		}
+		if n > last {
+			pms_serial_overruns_total.Add(float64(n - last))
+		}
+		last = n
+	}
+}
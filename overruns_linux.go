@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// serialIcounter is struct serial_icounter_struct from linux/serial.h.
+type serialIcounter struct {
+	cts, dsr, rng, dcd, rx, tx int32
+	frame, overrun, parity     int32
+	brk, bufOverrun            int32
+	reserved                   [9]int32
+}
+
+// serialOverruns returns the driver's count of hardware and buffer overruns
+// for port, via the TIOCGICOUNT ioctl.
+func serialOverruns(port io.ReadWriteCloser) (uint64, error) {
+	sc, ok := port.(syscall.Conn)
+	if !ok {
+		return 0, errors.New("not a file")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var ic serialIcounter
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = unix.Syscall(unix.SYS_IOCTL, fd, unix.TIOCGICOUNT, uintptr(unsafe.Pointer(&ic)))
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+	return uint64(ic.overrun) + uint64(ic.bufOverrun), nil
+}
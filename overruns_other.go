@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func serialOverruns(port io.ReadWriteCloser) (uint64, error) {
+	return 0, errors.New("only supported on Linux")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pms_read_panics_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_read_panics_total",
+			Help: "Times the serial reader panicked and was restarted",
+		},
+	)
+
+	pms_export_panics_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_export_panics_total",
+			Help: "Times exporting a reading, to the metrics or a sink, panicked and the exporter was restarted",
+		},
+	)
+)
+
+// panicRestartDelay is how long restartOnPanic waits before rerunning. It's a
+// var so tests needn't wait.
+var panicRestartDelay = time.Second
+
+// restartOnPanic runs f until it returns normally, logging, counting in
+// panics and rerunning it after panicRestartDelay whenever it panics. A
+// parser or exporter bug then costs a frame rather than the whole process.
+func restartOnPanic(panics prometheus.Counter, f func()) {
+	for !runRecovered(f) {
+		panics.Inc()
+		time.Sleep(panicRestartDelay)
+	}
+}
+
+// runRecovered runs f, reporting whether it returned without panicking.
+func runRecovered(f func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := make([]byte, 16<<10)
+			stack = stack[:runtime.Stack(stack, false)]
+			log.Printf("Recovered from panic, restarting in %v: %v\n%s", panicRestartDelay, r, stack)
+		}
+	}()
+	f()
+	return true
+}
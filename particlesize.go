@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// particleSizeCollector exports the latest particle counts as a histogram of
+// particle diameter, for -particle-size-histogram.
+//
+// The sensor counts particles at or above each size, whereas a Prometheus
+// bucket counts observations at or below its le. So the count is the number
+// at or above 0.3um, the smallest size the sensor sees, and the bucket for
+// each larger size is that count minus the number at or above the size.
+// Particles exactly on a boundary land in the bucket above it rather than at
+// it, and the sensor doesn't report sizes, so the sum is NaN.
+type particleSizeCollector struct {
+	desc *prometheus.Desc
+}
+
+func newParticleSizeCollector() *particleSizeCollector {
+	return &particleSizeCollector{prometheus.NewDesc(
+		"pms_particle_size_microns",
+		"Diameter of particles in 0.1L of air, from the latest particle counts",
+		nil, nil,
+	)}
+}
+
+func (c *particleSizeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *particleSizeCollector) Collect(ch chan<- prometheus.Metric) {
+	latestMu.Lock()
+	l := latest
+	latestMu.Unlock()
+	if l == nil {
+		return
+	}
+	count, buckets := particleSizeBuckets(&l.Reading)
+	ch <- prometheus.MustNewConstHistogram(c.desc, count, math.NaN(), buckets)
+}
+
+// particleSizeBuckets turns the cumulative at-or-above counts into histogram
+// buckets keyed by upper bound in microns. Buckets never decrease, even if a
+// corrupt frame has more large particles than small ones.
+func particleSizeBuckets(pms *PMS5003) (uint64, map[float64]uint64) {
+	count := uint64(pms.Particles3um)
+	buckets := map[float64]uint64{}
+	var le uint64
+	for _, b := range []struct {
+		microns float64
+		atLeast uint16
+	}{
+		{0.5, pms.Particles5um},
+		{1, pms.Particles10um},
+		{2.5, pms.Particles25um},
+		{5, pms.Particles50um},
+		{10, pms.Particles100um},
+	} {
+		if n := uint64(b.atLeast); n < count && count-n > le {
+			le = count - n
+		}
+		buckets[b.microns] = le
+	}
+	return count, buckets
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	processStart = time.Now()
+	// awake is how long the poller has kept the sensor awake.
+	awake time.Duration
+
+	pms_fan_duty_cycle = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_fan_duty_cycle",
+			Help: "Fraction of time the sensor has been awake since startup, in -mode=passive",
+		},
+	)
+
+	pms_passive_unexpected_bytes_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_passive_unexpected_bytes_total",
+			Help: "Bytes skipped in -mode=passive that were neither a frame nor a command reply, such as status bytes some firmware adds around its reply to a read request",
+		},
+	)
+
+	pms_scrape_read_timeouts_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_scrape_read_timeouts_total",
+			Help: "Scrapes in -mode=passive that gave up waiting for fresh readings after -scrape-read-timeout",
+		},
+	)
+
+	pms_scrape_reading_stale = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_scrape_reading_stale",
+			Help: "1 if this scrape serves older readings, as of pms_last_reading_timestamp_seconds, because it timed out waiting for fresh ones or the poller was busy",
+		},
+	)
+
+	// scrapeRequests carries a scrape's request for fresh readings to the
+	// passive mode poller, which closes the channel it's sent when done.
+	scrapeRequests = make(chan chan struct{})
+)
+
+// passiveFrameTimeout bounds the wait for each requested frame.
+const passiveFrameTimeout = 2 * time.Second
+
+// pollOnScrape has the sensor take fresh readings before h serves a scrape.
+// If the poller isn't ready, for example because a previous scrape is still
+// waiting on it or the port is closed, h serves the last readings right away,
+// marked stale.
+// It waits at most timeout for the readings, if that's nonzero, then serves
+// the last ones and leaves the poller to finish in the background.
+func pollOnScrape(h http.Handler, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		done := make(chan struct{})
+		stale := 0.0
+		select {
+		case scrapeRequests <- done:
+			select {
+			case <-done:
+			case <-ctx.Done():
+				log.Printf("Scrape gave up waiting for fresh readings: %v\n", ctx.Err())
+				pms_scrape_read_timeouts_total.Inc()
+				stale = 1
+			}
+		default:
+			// The poller is busy; the readings are as old as they are.
+			stale = 1
+		}
+		pms_scrape_reading_stale.Set(stale)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// pollPassive keeps the sensor asleep in passive mode, waking it to read
+// frames for each scrape, until stop is closed. Sleeping the fan between
+// infrequent scrapes prolongs the laser's life.
+func pollPassive(w io.Writer, stop <-chan struct{}) {
+	if err := writeCommand(w, cmdChangeMode, modePassive); err != nil {
+		log.Printf("pollPassive: passive mode: %v\n", err)
+	}
+	if err := writeCommand(w, cmdSleep, sleepSleep); err != nil {
+		log.Printf("pollPassive: sleep: %v\n", err)
+	}
+	for {
+		var done chan struct{}
+		select {
+		case <-stop:
+			return
+		case done = <-scrapeRequests:
+		}
+		readPassive(w)
+		close(done)
+	}
+}
+
+// readPassive wakes the sensor, waits for its fan to warm up, requests
+// -passive-frames frames and puts it back to sleep. The frames themselves are
+// read by readFrames, which resyncs on the magic bytes, so any bytes firmware
+// sends around its reply are skipped and counted rather than misread.
+func readPassive(w io.Writer) {
+	woke := time.Now()
+	defer func() {
+		if err := writeCommand(w, cmdSleep, sleepSleep); err != nil {
+			log.Printf("readPassive: sleep: %v\n", err)
+		}
+		awake += time.Since(woke)
+		addRuntime(time.Since(woke))
+		pms_fan_duty_cycle.Set(awake.Seconds() / time.Since(processStart).Seconds())
+	}()
+	if err := writeCommand(w, cmdSleep, sleepWake); err != nil {
+		log.Printf("readPassive: wake: %v\n", err)
+		return
+	}
+	time.Sleep(*passiveWarmup)
+	for i := 0; i < *passiveFrames; i++ {
+		before := sessionReadings.Load()
+		if err := writeCommand(w, cmdReadPassive, 0); err != nil {
+			log.Printf("readPassive: read: %v\n", err)
+			return
+		}
+		for deadline := time.Now().Add(passiveFrameTimeout); sessionReadings.Load() == before; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				log.Println("readPassive: timed out waiting for a frame")
+				break
+			}
+		}
+	}
+}
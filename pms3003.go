@@ -0,0 +1,53 @@
+package main
+
+import "encoding/binary"
+
+// pms3003Length is the length field of a PMS3003 frame.
+const pms3003Length = 20
+
+// PMS3003 wraps an air quality packet from the older PMS3003, which reports
+// only mass concentrations: https://aqicn.org/air/view/sensor/spec/pms3003.pdf
+type PMS3003 struct {
+	Length   uint16
+	Pm10Std  uint16
+	Pm25Std  uint16
+	Pm100Std uint16
+	Pm10Env  uint16
+	Pm25Env  uint16
+	Pm100Env uint16
+	Reserved [3]uint16
+	Checksum uint16
+}
+
+func (p *PMS3003) valid() bool {
+	return p.Length == pms3003Length
+}
+
+// decodePMS3003 decodes the 22 bytes following the magic into p.
+func decodePMS3003(buf []byte, p *PMS3003) {
+	be := binary.BigEndian
+	for i, m := range [...]*uint16{
+		&p.Length,
+		&p.Pm10Std, &p.Pm25Std, &p.Pm100Std,
+		&p.Pm10Env, &p.Pm25Env, &p.Pm100Env,
+		&p.Reserved[0], &p.Reserved[1], &p.Reserved[2],
+		&p.Checksum,
+	} {
+		*m = be.Uint16(buf[2*i:])
+	}
+}
+
+// pms5003 returns p as a PMS5003 frame without particle counts, so it can
+// be exported like one. The Length is kept, so valid() still tells them apart.
+func (p *PMS3003) pms5003() *PMS5003 {
+	return &PMS5003{
+		Length:   p.Length,
+		Pm10Std:  p.Pm10Std,
+		Pm25Std:  p.Pm25Std,
+		Pm100Std: p.Pm100Std,
+		Pm10Env:  p.Pm10Env,
+		Pm25Env:  p.Pm25Env,
+		Pm100Env: p.Pm100Env,
+		Checksum: p.Checksum,
+	}
+}
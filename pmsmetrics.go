@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pmsGatherer gathers only the pms_ metrics from g, for /metrics/pms.
+type pmsGatherer struct {
+	g prometheus.Gatherer
+}
+
+func (p pmsGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := p.g.Gather()
+	var pms []*dto.MetricFamily
+	for _, mf := range mfs {
+		if strings.HasPrefix(mf.GetName(), "pms_") {
+			pms = append(pms, mf)
+		}
+	}
+	return pms, err
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "io"
+
+// pollable returns port as is: outside Unix go-serial's port isn't a file
+// the runtime can poll.
+func pollable(port io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	return port, nil
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// pollable returns the serial port as a file in the runtime's poller, so
+// closing it unblocks a Read waiting on a silent sensor. go-serial leaves its
+// fd in blocking mode, where Close doesn't interrupt the Read.
+func pollable(port io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	f, ok := port.(*os.File)
+	if !ok {
+		return port, nil
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return nil, os.NewSyscallError("dup", err)
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("setnonblock", err)
+	}
+	// The dup shares the termios settings, so the original can go.
+	f.Close()
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+var pms_push_failures_total = promauto.With(registry).NewCounter(
+	prometheus.CounterOpts{
+		Name: "pms_push_failures_total",
+		Help: "Failed pushes to -pushgateway-url",
+	},
+)
+
+// maxPushBackoff caps how long pushForever waits after repeated failures.
+const maxPushBackoff = 5 * time.Minute
+
+// pushForever pushes the registry to the Pushgateway at url every interval.
+// After a failure it doubles the wait, up to maxPushBackoff, until a push
+// succeeds.
+func pushForever(url, job string, interval time.Duration) {
+	pusher := push.New(url, job).Gatherer(registry)
+	wait := interval
+	for {
+		time.Sleep(wait)
+		if err := pusher.Push(); err != nil {
+			pms_push_failures_total.Inc()
+			wait *= 2
+			if wait > maxPushBackoff {
+				wait = maxPushBackoff
+			}
+			// The Pushgateway client's errors quote the URL, credentials and all.
+			msg := strings.ReplaceAll(err.Error(), url, redactURL(url))
+			log.Printf("Push to %v failed, retrying in %v: %v\n", redactURL(url), wait, msg)
+			continue
+		}
+		wait = interval
+	}
+}
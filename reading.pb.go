@@ -0,0 +1,323 @@
+// A single PMS5003 reading, as written by breathe -protobuf-output. Each record
+// is preceded by its length as a varint, as with Java's writeDelimitedTo or
+// Go's protodelim.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: reading.proto
+
+package main
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Reading struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Unix time of the reading, in milliseconds.
+	TimestampMs int64 `protobuf:"varint,1,opt,name=timestamp_ms,json=timestampMs,proto3" json:"timestamp_ms,omitempty"`
+	// Count of valid frames since breathe started.
+	Sequence uint64 `protobuf:"varint,2,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// Micrograms per cubic meter, standard particle (CF=1).
+	Pm1Std  uint32 `protobuf:"varint,3,opt,name=pm1_std,json=pm1Std,proto3" json:"pm1_std,omitempty"`
+	Pm25Std uint32 `protobuf:"varint,4,opt,name=pm25_std,json=pm25Std,proto3" json:"pm25_std,omitempty"`
+	Pm10Std uint32 `protobuf:"varint,5,opt,name=pm10_std,json=pm10Std,proto3" json:"pm10_std,omitempty"`
+	// Micrograms per cubic meter, adjusted for atmospheric environment.
+	Pm1Env  uint32 `protobuf:"varint,6,opt,name=pm1_env,json=pm1Env,proto3" json:"pm1_env,omitempty"`
+	Pm25Env uint32 `protobuf:"varint,7,opt,name=pm25_env,json=pm25Env,proto3" json:"pm25_env,omitempty"`
+	Pm10Env uint32 `protobuf:"varint,8,opt,name=pm10_env,json=pm10Env,proto3" json:"pm10_env,omitempty"`
+	// Particles beyond the given diameter in 0.1L of air.
+	Particles_03Um  uint32 `protobuf:"varint,9,opt,name=particles_03um,json=particles03um,proto3" json:"particles_03um,omitempty"`
+	Particles_05Um  uint32 `protobuf:"varint,10,opt,name=particles_05um,json=particles05um,proto3" json:"particles_05um,omitempty"`
+	Particles_10Um  uint32 `protobuf:"varint,11,opt,name=particles_10um,json=particles10um,proto3" json:"particles_10um,omitempty"`
+	Particles_25Um  uint32 `protobuf:"varint,12,opt,name=particles_25um,json=particles25um,proto3" json:"particles_25um,omitempty"`
+	Particles_50Um  uint32 `protobuf:"varint,13,opt,name=particles_50um,json=particles50um,proto3" json:"particles_50um,omitempty"`
+	Particles_100Um uint32 `protobuf:"varint,14,opt,name=particles_100um,json=particles100um,proto3" json:"particles_100um,omitempty"`
+	Version         uint32 `protobuf:"varint,15,opt,name=version,proto3" json:"version,omitempty"`
+	ErrorCode       uint32 `protobuf:"varint,16,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	// US EPA AQI, from the environmental values.
+	AqiPm25 float64 `protobuf:"fixed64,17,opt,name=aqi_pm25,json=aqiPm25,proto3" json:"aqi_pm25,omitempty"`
+	AqiPm10 float64 `protobuf:"fixed64,18,opt,name=aqi_pm10,json=aqiPm10,proto3" json:"aqi_pm10,omitempty"`
+}
+
+func (x *Reading) Reset() {
+	*x = Reading{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_reading_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Reading) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Reading) ProtoMessage() {}
+
+func (x *Reading) ProtoReflect() protoreflect.Message {
+	mi := &file_reading_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Reading.ProtoReflect.Descriptor instead.
+func (*Reading) Descriptor() ([]byte, []int) {
+	return file_reading_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Reading) GetTimestampMs() int64 {
+	if x != nil {
+		return x.TimestampMs
+	}
+	return 0
+}
+
+func (x *Reading) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *Reading) GetPm1Std() uint32 {
+	if x != nil {
+		return x.Pm1Std
+	}
+	return 0
+}
+
+func (x *Reading) GetPm25Std() uint32 {
+	if x != nil {
+		return x.Pm25Std
+	}
+	return 0
+}
+
+func (x *Reading) GetPm10Std() uint32 {
+	if x != nil {
+		return x.Pm10Std
+	}
+	return 0
+}
+
+func (x *Reading) GetPm1Env() uint32 {
+	if x != nil {
+		return x.Pm1Env
+	}
+	return 0
+}
+
+func (x *Reading) GetPm25Env() uint32 {
+	if x != nil {
+		return x.Pm25Env
+	}
+	return 0
+}
+
+func (x *Reading) GetPm10Env() uint32 {
+	if x != nil {
+		return x.Pm10Env
+	}
+	return 0
+}
+
+func (x *Reading) GetParticles_03Um() uint32 {
+	if x != nil {
+		return x.Particles_03Um
+	}
+	return 0
+}
+
+func (x *Reading) GetParticles_05Um() uint32 {
+	if x != nil {
+		return x.Particles_05Um
+	}
+	return 0
+}
+
+func (x *Reading) GetParticles_10Um() uint32 {
+	if x != nil {
+		return x.Particles_10Um
+	}
+	return 0
+}
+
+func (x *Reading) GetParticles_25Um() uint32 {
+	if x != nil {
+		return x.Particles_25Um
+	}
+	return 0
+}
+
+func (x *Reading) GetParticles_50Um() uint32 {
+	if x != nil {
+		return x.Particles_50Um
+	}
+	return 0
+}
+
+func (x *Reading) GetParticles_100Um() uint32 {
+	if x != nil {
+		return x.Particles_100Um
+	}
+	return 0
+}
+
+func (x *Reading) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Reading) GetErrorCode() uint32 {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return 0
+}
+
+func (x *Reading) GetAqiPm25() float64 {
+	if x != nil {
+		return x.AqiPm25
+	}
+	return 0
+}
+
+func (x *Reading) GetAqiPm10() float64 {
+	if x != nil {
+		return x.AqiPm10
+	}
+	return 0
+}
+
+var File_reading_proto protoreflect.FileDescriptor
+
+var file_reading_proto_rawDesc = []byte{
+	0x0a, 0x0d, 0x72, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12,
+	0x07, 0x62, 0x72, 0x65, 0x61, 0x74, 0x68, 0x65, 0x22, 0xc1, 0x04, 0x0a, 0x07, 0x52, 0x65, 0x61,
+	0x64, 0x69, 0x6e, 0x67, 0x12, 0x21, 0x0a, 0x0c, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x5f, 0x6d, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x74, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x4d, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65,
+	0x6e, 0x63, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65,
+	0x6e, 0x63, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x6d, 0x31, 0x5f, 0x73, 0x74, 0x64, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x70, 0x6d, 0x31, 0x53, 0x74, 0x64, 0x12, 0x19, 0x0a, 0x08,
+	0x70, 0x6d, 0x32, 0x35, 0x5f, 0x73, 0x74, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07,
+	0x70, 0x6d, 0x32, 0x35, 0x53, 0x74, 0x64, 0x12, 0x19, 0x0a, 0x08, 0x70, 0x6d, 0x31, 0x30, 0x5f,
+	0x73, 0x74, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x70, 0x6d, 0x31, 0x30, 0x53,
+	0x74, 0x64, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x6d, 0x31, 0x5f, 0x65, 0x6e, 0x76, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x06, 0x70, 0x6d, 0x31, 0x45, 0x6e, 0x76, 0x12, 0x19, 0x0a, 0x08, 0x70,
+	0x6d, 0x32, 0x35, 0x5f, 0x65, 0x6e, 0x76, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x70,
+	0x6d, 0x32, 0x35, 0x45, 0x6e, 0x76, 0x12, 0x19, 0x0a, 0x08, 0x70, 0x6d, 0x31, 0x30, 0x5f, 0x65,
+	0x6e, 0x76, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x70, 0x6d, 0x31, 0x30, 0x45, 0x6e,
+	0x76, 0x12, 0x25, 0x0a, 0x0e, 0x70, 0x61, 0x72, 0x74, 0x69, 0x63, 0x6c, 0x65, 0x73, 0x5f, 0x30,
+	0x33, 0x75, 0x6d, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0d, 0x70, 0x61, 0x72, 0x74, 0x69,
+	0x63, 0x6c, 0x65, 0x73, 0x30, 0x33, 0x75, 0x6d, 0x12, 0x25, 0x0a, 0x0e, 0x70, 0x61, 0x72, 0x74,
+	0x69, 0x63, 0x6c, 0x65, 0x73, 0x5f, 0x30, 0x35, 0x75, 0x6d, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x0d, 0x70, 0x61, 0x72, 0x74, 0x69, 0x63, 0x6c, 0x65, 0x73, 0x30, 0x35, 0x75, 0x6d, 0x12,
+	0x25, 0x0a, 0x0e, 0x70, 0x61, 0x72, 0x74, 0x69, 0x63, 0x6c, 0x65, 0x73, 0x5f, 0x31, 0x30, 0x75,
+	0x6d, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0d, 0x70, 0x61, 0x72, 0x74, 0x69, 0x63, 0x6c,
+	0x65, 0x73, 0x31, 0x30, 0x75, 0x6d, 0x12, 0x25, 0x0a, 0x0e, 0x70, 0x61, 0x72, 0x74, 0x69, 0x63,
+	0x6c, 0x65, 0x73, 0x5f, 0x32, 0x35, 0x75, 0x6d, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0d,
+	0x70, 0x61, 0x72, 0x74, 0x69, 0x63, 0x6c, 0x65, 0x73, 0x32, 0x35, 0x75, 0x6d, 0x12, 0x25, 0x0a,
+	0x0e, 0x70, 0x61, 0x72, 0x74, 0x69, 0x63, 0x6c, 0x65, 0x73, 0x5f, 0x35, 0x30, 0x75, 0x6d, 0x18,
+	0x0d, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0d, 0x70, 0x61, 0x72, 0x74, 0x69, 0x63, 0x6c, 0x65, 0x73,
+	0x35, 0x30, 0x75, 0x6d, 0x12, 0x27, 0x0a, 0x0f, 0x70, 0x61, 0x72, 0x74, 0x69, 0x63, 0x6c, 0x65,
+	0x73, 0x5f, 0x31, 0x30, 0x30, 0x75, 0x6d, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0e, 0x70,
+	0x61, 0x72, 0x74, 0x69, 0x63, 0x6c, 0x65, 0x73, 0x31, 0x30, 0x30, 0x75, 0x6d, 0x12, 0x18, 0x0a,
+	0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07,
+	0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x1d, 0x0a, 0x0a, 0x65, 0x72, 0x72, 0x6f, 0x72,
+	0x5f, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x10, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x65, 0x72, 0x72,
+	0x6f, 0x72, 0x43, 0x6f, 0x64, 0x65, 0x12, 0x19, 0x0a, 0x08, 0x61, 0x71, 0x69, 0x5f, 0x70, 0x6d,
+	0x32, 0x35, 0x18, 0x11, 0x20, 0x01, 0x28, 0x01, 0x52, 0x07, 0x61, 0x71, 0x69, 0x50, 0x6d, 0x32,
+	0x35, 0x12, 0x19, 0x0a, 0x08, 0x61, 0x71, 0x69, 0x5f, 0x70, 0x6d, 0x31, 0x30, 0x18, 0x12, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x07, 0x61, 0x71, 0x69, 0x50, 0x6d, 0x31, 0x30, 0x42, 0x21, 0x5a, 0x1f,
+	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6d, 0x68, 0x61, 0x6e, 0x73,
+	0x65, 0x6e, 0x2f, 0x62, 0x72, 0x65, 0x61, 0x74, 0x68, 0x65, 0x3b, 0x6d, 0x61, 0x69, 0x6e, 0x62,
+	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_reading_proto_rawDescOnce sync.Once
+	file_reading_proto_rawDescData = file_reading_proto_rawDesc
+)
+
+func file_reading_proto_rawDescGZIP() []byte {
+	file_reading_proto_rawDescOnce.Do(func() {
+		file_reading_proto_rawDescData = protoimpl.X.CompressGZIP(file_reading_proto_rawDescData)
+	})
+	return file_reading_proto_rawDescData
+}
+
+var file_reading_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_reading_proto_goTypes = []any{
+	(*Reading)(nil), // 0: breathe.Reading
+}
+var file_reading_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_reading_proto_init() }
+func file_reading_proto_init() {
+	if File_reading_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_reading_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*Reading); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_reading_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_reading_proto_goTypes,
+		DependencyIndexes: file_reading_proto_depIdxs,
+		MessageInfos:      file_reading_proto_msgTypes,
+	}.Build()
+	File_reading_proto = out.File
+	file_reading_proto_rawDesc = nil
+	file_reading_proto_goTypes = nil
+	file_reading_proto_depIdxs = nil
+}
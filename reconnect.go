@@ -0,0 +1,45 @@
+package main
+
+import "time"
+
+const (
+	// minReconnectBackoff is how long readPortForever waits before reopening
+	// the serial port, doubling after each failed session up to
+	// maxReconnectBackoff.
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// reconnectLimiter is a token bucket allowing at most burst reconnects per
+// period. Rapidly reopening a device can wedge a whole USB hub, which is worse
+// than waiting.
+type reconnectLimiter struct {
+	burst  float64
+	period time.Duration
+	tokens float64
+	last   time.Time
+}
+
+func newReconnectLimiter(burst int, period time.Duration) *reconnectLimiter {
+	return &reconnectLimiter{
+		burst:  float64(burst),
+		period: period,
+		tokens: float64(burst),
+	}
+}
+
+// allow takes a token if one is available at time now.
+func (l *reconnectLimiter) allow(now time.Time) bool {
+	if !l.last.IsZero() {
+		l.tokens += l.burst * float64(now.Sub(l.last)) / float64(l.period)
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
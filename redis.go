@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pms_redis_errors_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_redis_errors_total",
+			Help: "Failed XADDs to -redis-stream",
+		},
+	)
+
+	pms_redis_dropped_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_redis_dropped_total",
+			Help: "Readings dropped because the Redis queue was full",
+		},
+	)
+)
+
+// redisTimeout bounds each connection attempt and XADD.
+const redisTimeout = 5 * time.Second
+
+// readingFields returns the reading as name/value pairs, in a fixed order.
+func readingFields(pms *PMS5003) [][2]string {
+	var fields [][2]string
+	add := func(name string, v uint16) {
+		fields = append(fields, [2]string{name, strconv.Itoa(int(v))})
+	}
+	add("pm1_std", pms.Pm10Std)
+	add("pm25_std", pms.Pm25Std)
+	add("pm10_std", pms.Pm100Std)
+	add("pm1_env", pms.Pm10Env)
+	add("pm25_env", pms.Pm25Env)
+	add("pm10_env", pms.Pm100Env)
+	add("particles_03um", pms.Particles3um)
+	add("particles_05um", pms.Particles5um)
+	add("particles_10um", pms.Particles10um)
+	add("particles_25um", pms.Particles25um)
+	add("particles_50um", pms.Particles50um)
+	add("particles_100um", pms.Particles100um)
+	return fields
+}
+
+// sinkFields returns readingFields for the sinks, leaving out the particle
+// counts of PMS3003 frames, which have none, and zero particle counts with
+// -suppress-zero-counts.
+func sinkFields(pms *PMS5003) [][2]string {
+	fields := readingFields(pms)
+	noCounts := pms.Length == pms3003Length
+	if !noCounts && !*suppressZeroCounts {
+		return fields
+	}
+	kept := fields[:0]
+	for _, f := range fields {
+		if strings.HasPrefix(f[0], "particles_") && (noCounts || f[1] == "0") {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+// redisConn is a connection to Redis and a reader for its replies.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// newRedisPublisher returns a sink appending readings to stream on the Redis
+// server at addr.
+func newRedisPublisher(addr, stream string) *queuedSink {
+	q := &queuedSink{
+		name:    "redis",
+		timeout: redisTimeout,
+		errors:  pms_redis_errors_total,
+		dropped: pms_redis_dropped_total,
+		encode: func(pms *PMS5003, t time.Time, seq uint64) []byte {
+			return xaddCommand(stream, pms, t)
+		},
+		dial: func() (io.WriteCloser, error) {
+			conn, err := net.DialTimeout("tcp", addr, redisTimeout)
+			if err != nil {
+				return nil, err
+			}
+			return &redisConn{conn, bufio.NewReader(conn)}, nil
+		},
+		ack: func(w io.WriteCloser) error { return xaddReply(w.(*redisConn).r) },
+	}
+	return q.start()
+}
+
+// xaddCommand encodes the XADD appending a reading to stream.
+func xaddCommand(stream string, pms *PMS5003, t time.Time) []byte {
+	args := []string{"XADD", stream, "*", "timestamp", strconv.FormatInt(t.UnixMilli(), 10)}
+	for _, f := range sinkFields(pms) {
+		args = append(args, f[0], f[1])
+	}
+	return respCommand(args)
+}
+
+// xaddReply reads Redis's reply to an XADD.
+func xaddReply(r *bufio.Reader) error {
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	switch reply[0] {
+	case '-':
+		return fmt.Errorf("XADD: %s", strings.TrimRight(reply[1:], "\r\n"))
+	case '$':
+		// The new entry's ID follows as a bulk string.
+		_, err = r.ReadString('\n')
+		return err
+	}
+	return fmt.Errorf("XADD: unexpected reply %q", reply)
+}
+
+// respCommand encodes a command in the Redis serialization protocol.
+func respCommand(args []string) []byte {
+	b := []byte(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, a := range args {
+		b = append(b, fmt.Sprintf("$%d\r\n%s\r\n", len(a), a)...)
+	}
+	return b
+}
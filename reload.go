@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+)
+
+var (
+	// configMu guards the flags in reloadableFlags, which SIGHUP may change
+	// while they're in use. Their readers hold it for reading.
+	configMu sync.RWMutex
+
+	// reloadableFlags may be changed by a SIGHUP. They're only read with
+	// configMu held, by updateMetrics.
+	reloadableFlags = map[string]bool{
+		"max-plausible-pm":           true,
+		"count-saturation-threshold": true,
+		"median-filter":              true,
+	}
+)
+
+// reloadOnSIGHUP re-reads the -config file at path on every SIGHUP.
+func reloadOnSIGHUP(path string) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		log.Printf("SIGHUP: reloading %v\n", path)
+		if err := reloadConfigFile(path); err != nil {
+			log.Printf("reloadConfigFile: %v\n", err)
+		}
+	}
+}
+
+// reloadConfigFile applies changes to reloadable flags from the YAML file at
+// path, all at once or not at all. Changes to other flags are only logged,
+// as they need a restart.
+func reloadConfigFile(path string) error {
+	config, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	changes := map[*flag.Flag]string{}
+	var errs []error
+	for f, v := range config {
+		// Parse into a scratch value of the same type, so e.g. 60s and 1m
+		// compare equal.
+		scratch := reflect.New(reflect.TypeOf(f.Value).Elem()).Interface().(flag.Value)
+		if err := scratch.Set(v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, f.Name, err))
+			continue
+		}
+		if scratch.String() == f.Value.String() {
+			continue
+		}
+		if !reloadableFlags[f.Name] {
+			log.Printf("-%s changed from %v to %v, which needs a restart to take effect.\n", f.Name, f.Value, scratch)
+			continue
+		}
+		changes[f] = scratch.String()
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+	old := map[*flag.Flag]string{}
+	// The flags newly set from the file, so validateFlags checks their rules.
+	var added []string
+	for f, v := range changes {
+		old[f] = f.Value.String()
+		f.Value.Set(v)
+		if !configFileFlags[f.Name] {
+			configFileFlags[f.Name] = true
+			added = append(added, f.Name)
+		}
+	}
+	if err := validateFlags(); err != nil {
+		for f, v := range old {
+			f.Value.Set(v)
+		}
+		for _, name := range added {
+			delete(configFileFlags, name)
+		}
+		return err
+	}
+	for f := range changes {
+		log.Printf("-%s changed from %v to %v.\n", f.Name, old[f], f.Value)
+	}
+	return nil
+}
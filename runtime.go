@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pms_laser_runtime_seconds_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_laser_runtime_seconds_total",
+			Help: "Time the sensor has spent awake and measuring. Persisted across restarts with -state-file",
+		},
+	)
+
+	pms_laser_life_remaining_hours = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_laser_life_remaining_hours",
+			Help: "-laser-rated-hours less the laser's runtime so far",
+		},
+	)
+
+	pms_laser_life_fraction_used = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_laser_life_fraction_used",
+			Help: "Fraction of -laser-rated-hours used so far",
+		},
+	)
+
+	runtimeMu    sync.Mutex
+	runtimeTotal time.Duration
+)
+
+// maxStreamingGap is the longest gap between active mode frames still counted
+// as runtime. Longer gaps mean the sensor was unplugged or the port closed.
+const maxStreamingGap = 10 * time.Second
+
+// addRuntime records d more time with the laser on.
+func addRuntime(d time.Duration) {
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+	runtimeTotal += d
+	pms_laser_runtime_seconds_total.Add(d.Seconds())
+	used := runtimeTotal.Hours() / *laserRatedHours
+	pms_laser_life_fraction_used.Set(used)
+	remaining := *laserRatedHours - runtimeTotal.Hours()
+	if remaining < 0 {
+		remaining = 0
+	}
+	pms_laser_life_remaining_hours.Set(remaining)
+}
+
+// laserRuntime returns the total runtime recorded.
+func laserRuntime() time.Duration {
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+	return runtimeTotal
+}
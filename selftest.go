@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// selfTest waits up to timeout for the sensor to produce its first valid
+// reading since start, and returns it.
+func selfTest(start time.Time, timeout time.Duration) (*jsonReading, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		latestMu.Lock()
+		l := latest
+		latestMu.Unlock()
+		if l != nil && !l.Time.Before(start) {
+			return l, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, fmt.Errorf("no valid reading within %v", timeout)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+const (
+	// detectAgreeing is how many consecutive frames must agree on a model
+	// before -sensor=auto locks to it.
+	detectAgreeing = 3
+	// detectMaxFrames is how many frames -sensor=auto reads before giving up
+	// on agreement and assuming a PMS5003.
+	detectMaxFrames = 10
+)
+
+// detectedSensor holds the model -sensor=auto detected, once it has.
+var detectedSensor atomic.Value // string
+
+// sensorModel returns the model frames are read as: -sensor, or with
+// -sensor=auto the detected model, or "auto" while still detecting.
+func sensorModel() string {
+	if *sensor != "auto" {
+		return *sensor
+	}
+	if s, ok := detectedSensor.Load().(string); ok {
+		return s
+	}
+	return "auto"
+}
+
+// sensorDetector infers the model from the frames -sensor=auto reads.
+type sensorDetector struct {
+	seen     int
+	model    string
+	agreeing int
+}
+
+// observe records a frame that passed its checksum, returning the detected
+// model once there is one, else "".
+func (d *sensorDetector) observe(p *PMS5003) string {
+	d.seen++
+	model := ""
+	switch p.Length {
+	case 28:
+		model = "pms5003"
+	case pms3003Length:
+		model = "pms3003"
+	}
+	// A frame that passed its checksum by chance is unlikely to look sane too.
+	// A SIGHUP may change -max-plausible-pm under us.
+	configMu.RLock()
+	maxPM := uint16(*maxPlausiblePM)
+	configMu.RUnlock()
+	if model == "" || !p.plausible(maxPM) || !p.consistent() {
+		model = ""
+	}
+	if model != "" && model == d.model {
+		d.agreeing++
+	} else {
+		d.model, d.agreeing = model, 1
+	}
+	switch {
+	case d.model != "" && d.agreeing >= detectAgreeing:
+		log.Printf("Detected a %v from the sensor's frames (-sensor=auto).\n", d.model)
+		return d.model
+	case d.seen >= detectMaxFrames:
+		log.Printf("WARNING: -sensor=auto: no %d consecutive frames agreed on a model in %d frames, assuming a pms5003.\n", detectAgreeing, d.seen)
+		return "pms5003"
+	}
+	return ""
+}
+
+// lockSensor makes model the one frames are read and exported as from now on.
+func lockSensor(model string) {
+	detectedSensor.Store(model)
+	pms_mode_info.Reset()
+	setModeInfo(model)
+}
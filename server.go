@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+)
+
+// listenAddrs returns the addresses to serve metrics and the UI on, which are
+// the same unless both -metrics-listen and -ui-listen are set.
+func listenAddrs() (metrics, ui string) {
+	switch {
+	case *metricsListen != "" && *uiListen != "":
+		return *metricsListen, *uiListen
+	case *metricsListen != "":
+		return *metricsListen, *metricsListen
+	case *uiListen != "":
+		return *uiListen, *uiListen
+	default:
+		return *port, *port
+	}
+}
+
+// withPathPrefix serves h under prefix, which it strips from request paths
+// before passing them on. An empty prefix serves h as is.
+func withPathPrefix(prefix string, h http.Handler) http.Handler {
+	if prefix == "" {
+		return h
+	}
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, h))
+	return mux
+}
+
+// serve runs every server until one fails, then shuts the rest down and
+// returns the failure. It binds every address before serving any, so a taken
+// port is reported as such, without briefly serving on the others.
+func serve(servers []*http.Server) error {
+	var listeners []net.Listener
+	for _, s := range servers {
+		l, err := net.Listen("tcp", s.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("binding %v: %w", s.Addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+	errc := make(chan error, len(servers))
+	for i, s := range servers {
+		s, l := s, listeners[i]
+		go func() {
+			err := s.Serve(l)
+			log.Printf("serving %v: %v\n", s.Addr, err)
+			errc <- err
+		}()
+	}
+	err := <-errc
+	for _, s := range servers {
+		s.Shutdown(context.Background())
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// serviceName is what breathe is registered as with the Windows Service
+// Control Manager.
+const serviceName = "breathe"
+
+// runCommand handles the command that may follow the flags, reporting
+// whether main is done. It runs before the flags are checked, as uninstall
+// needs none. install registers a Windows service running breathe
+// with the same flags, uninstall removes it, and run is what the service
+// runs, carrying on as usual under the Service Control Manager.
+func runCommand() (done bool, err error) {
+	if flag.NArg() > 1 {
+		return true, fmt.Errorf("want at most one command, got %q", flag.Args())
+	}
+	cmd := flag.Arg(0)
+	switch cmd {
+	case "":
+		return false, nil
+	case "install":
+		// The service runs with these flags, so check them now rather than
+		// when it fails to start.
+		if *portname == "" {
+			err = errors.New("set -portname to the sensor's serial port, e.g. -portname=COM3")
+		} else if err = validateFlags(); err == nil {
+			flags := os.Args[1 : len(os.Args)-flag.NArg()]
+			err = installService(append(flags, "run"))
+		}
+	case "uninstall":
+		err = uninstallService()
+	case "run":
+		if err := runService(); err != nil {
+			return true, fmt.Errorf("run: %w", err)
+		}
+		return false, nil
+	default:
+		return true, fmt.Errorf("unknown command %q: want install, uninstall or run", cmd)
+	}
+	if err != nil {
+		return true, fmt.Errorf("%s: %w", cmd, err)
+	}
+	return true, nil
+}
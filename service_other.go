@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+var errNotWindows = errors.New("only supported on Windows")
+
+func installService(args []string) error {
+	return errNotWindows
+}
+
+func uninstallService() error {
+	return errNotWindows
+}
+
+func runService() error {
+	return errNotWindows
+}
@@ -0,0 +1,119 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// installService registers breathe as an automatically started service run
+// with args. The service starts in System32, so paths in args should be
+// absolute.
+func installService(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the Service Control Manager: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "breathe",
+		Description: "Prometheus exporter for PMS5003 air quality sensors",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("creating service %v: %w", serviceName, err)
+	}
+	defer s.Close()
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("registering the event log source: %w", err)
+	}
+	log.Printf("Installed service %v running %v %v\n", serviceName, exe, strings.Join(args, " "))
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the Service Control Manager: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("opening service %v: %w", serviceName, err)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("deleting service %v: %w", serviceName, err)
+	}
+	return eventlog.Remove(serviceName)
+}
+
+// runService answers the Service Control Manager in the background while
+// main carries on, logging to the event log. When the service is stopped it
+// closes the serial port and exits.
+func runService() error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return errors.New("not started by the Service Control Manager. Leave out run to run in the foreground")
+	}
+	if l, err := eventlog.Open(serviceName); err == nil {
+		log.SetFlags(0)
+		log.SetOutput(eventLogWriter{l})
+	}
+	go func() {
+		if err := svc.Run(serviceName, serviceHandler{}); err != nil {
+			log.Fatalf("svc.Run: %v", err)
+		}
+		os.Exit(0)
+	}()
+	return nil
+}
+
+// eventLogWriter sends each log line to the event log, as a warning if it
+// says so.
+type eventLogWriter struct {
+	l *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	if strings.HasPrefix(msg, "WARNING") {
+		return len(p), w.l.Warning(1, msg)
+	}
+	return len(p), w.l.Info(1, msg)
+}
+
+type serviceHandler struct{}
+
+func (serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for r := range requests {
+		switch r.Cmd {
+		case svc.Interrogate:
+			status <- r.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			if c := currentOpenPort(); c != nil {
+				c.Close()
+			}
+			return false, 0
+		}
+	}
+	return false, 0
+}
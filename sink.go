@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var pms_sink_unchanged_total = promauto.With(registry).NewCounter(
+	prometheus.CounterOpts{
+		Name: "pms_sink_unchanged_total",
+		Help: "Readings -on-change-only didn't publish to the sinks, as no PM value changed by more than -change-threshold",
+	},
+)
+
+// sink is somewhere readings go besides the Prometheus metrics. publish is
+// called from the read loop, so it must not block.
+type sink interface {
+	publish(pms *PMS5003, t time.Time, seq uint64)
+}
+
+// sinks are the outputs enabled by flags, each sent every valid reading.
+var sinks []sink
+
+// newSinks returns the sinks the flags ask for.
+func newSinks() []sink {
+	var s []sink
+	if *protobufOutput != "" {
+		s = append(s, newProtobufWriter(*protobufOutput))
+	}
+	if *graphiteAddr != "" {
+		s = append(s, newGraphiteSender(*graphiteAddr, *graphitePrefix))
+	}
+	if *statsdAddr != "" {
+		s = append(s, newStatsdSender(*statsdAddr, *statsdPrefix))
+	}
+	if *redisAddr != "" {
+		s = append(s, newRedisPublisher(*redisAddr, *redisStream))
+	}
+	return s
+}
+
+// changeFilter picks the readings to publish with -on-change-only: those
+// where a PM value moved more than threshold since the last one published,
+// and otherwise one every heartbeat.
+type changeFilter struct {
+	threshold uint
+	heartbeat time.Duration
+
+	mu       sync.Mutex
+	last     [6]uint16
+	lastTime time.Time
+}
+
+// changed reports whether pms, read at t, should be published, and if so
+// records it as the last one published.
+func (f *changeFilter) changed(pms *PMS5003, t time.Time) bool {
+	values := [6]uint16{pms.Pm10Std, pms.Pm25Std, pms.Pm100Std, pms.Pm10Env, pms.Pm25Env, pms.Pm100Env}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	publish := f.lastTime.IsZero() || t.Sub(f.lastTime) >= f.heartbeat
+	for i, v := range values {
+		d := int(v) - int(f.last[i])
+		if d < 0 {
+			d = -d
+		}
+		if uint(d) > f.threshold {
+			publish = true
+		}
+	}
+	if publish {
+		f.last, f.lastTime = values, t
+	}
+	return publish
+}
+
+// sinkFilter is set by -on-change-only.
+var sinkFilter *changeFilter
+
+// publishToSinks sends a reading to every sink, unless -on-change-only
+// holds it back. The Prometheus metrics are updated regardless.
+func publishToSinks(pms *PMS5003, t time.Time, seq uint64) {
+	if sinkFilter != nil && !sinkFilter.changed(pms, t) {
+		pms_sink_unchanged_total.Inc()
+		return
+	}
+	for _, s := range sinks {
+		s.publish(pms, t, seq)
+	}
+}
+
+// sinkQueueLength is how many readings a queuedSink holds while its
+// destination is slow or unreachable.
+const sinkQueueLength = 64
+
+// queuedSink sends readings to a destination from its own goroutine, so a
+// slow or unreachable one never blocks the read loop. Readings are encoded
+// as they're queued and dropped if the queue is full. After an error it
+// closes the connection and dials again for the next reading.
+type queuedSink struct {
+	name    string
+	timeout time.Duration // bounds each write and ack on a net.Conn
+	errors  prometheus.Counter
+	dropped prometheus.Counter
+	encode  func(pms *PMS5003, t time.Time, seq uint64) []byte
+	dial    func() (io.WriteCloser, error)
+	// ack, if set, checks the destination's reply to each write.
+	ack func(w io.WriteCloser) error
+
+	queue chan []byte
+	w     io.WriteCloser
+}
+
+// start starts sending, and returns q for convenience.
+func (q *queuedSink) start() *queuedSink {
+	q.queue = make(chan []byte, sinkQueueLength)
+	go q.run()
+	return q
+}
+
+// publish queues a reading, dropping it if the queue is full.
+func (q *queuedSink) publish(pms *PMS5003, t time.Time, seq uint64) {
+	select {
+	case q.queue <- q.encode(pms, t, seq):
+	default:
+		q.dropped.Inc()
+	}
+}
+
+func (q *queuedSink) run() {
+	for b := range q.queue {
+		if err := q.send(b); err != nil {
+			log.Printf("%s: %v\n", q.name, err)
+			q.errors.Inc()
+			if q.w != nil {
+				q.w.Close()
+				q.w = nil
+			}
+		}
+	}
+}
+
+func (q *queuedSink) send(b []byte) error {
+	if q.w == nil {
+		w, err := q.dial()
+		if err != nil {
+			return err
+		}
+		q.w = w
+	}
+	if c, ok := q.w.(net.Conn); ok {
+		c.SetDeadline(time.Now().Add(q.timeout))
+	}
+	if _, err := q.w.Write(b); err != nil {
+		return err
+	}
+	if q.ack != nil {
+		return q.ack(q.w)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// savedState is the on-disk format of -state-file.
+type savedState struct {
+	Time         time.Time
+	Reading      PMS5003
+	LaserRuntime time.Duration
+}
+
+var (
+	// stateMu serializes writes to -state-file, and guards lastStateSave.
+	stateMu       sync.Mutex
+	lastStateSave time.Time
+)
+
+// throttledSaveState saves pms, read at t, unless the last save was less than
+// interval before. Each save syncs the disk, which is too much for every
+// reading on an SD card.
+func throttledSaveState(path string, interval time.Duration, pms *PMS5003, t time.Time) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if t.Sub(lastStateSave) < interval {
+		return
+	}
+	if err := saveState(path, pms, t); err != nil {
+		log.Printf("saveState: %v\n", err)
+		return
+	}
+	lastStateSave = t
+}
+
+// saveStateOnExit saves the latest reading when we're asked to stop, so the
+// readings since the last throttled save aren't lost, then exits.
+func saveStateOnExit(path string) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	sig := <-c
+	latestMu.Lock()
+	l := latest
+	latestMu.Unlock()
+	if l != nil {
+		stateMu.Lock()
+		if err := saveState(path, &l.Reading, l.Time); err != nil {
+			log.Printf("saveState: %v\n", err)
+		}
+		stateMu.Unlock()
+	}
+	log.Printf("%v: exiting.\n", sig)
+	os.Exit(0)
+}
+
+// saveState atomically replaces the file at path with pms and its read time,
+// so a crash mid-write never leaves a truncated file behind.
+func saveState(path string, pms *PMS5003, t time.Time) error {
+	b, err := json.Marshal(savedState{Time: t, Reading: *pms, LaserRuntime: laserRuntime()})
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// restoreState exports the reading saved at path, if any. The timestamp gauge
+// keeps the original read time so restored values aren't mistaken for fresh.
+func restoreState(path string) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("restoreState: %v\n", err)
+		return
+	}
+	var s savedState
+	if err := json.Unmarshal(b, &s); err != nil {
+		log.Printf("restoreState: %v\n", err)
+		return
+	}
+	log.Printf("Restored reading from %v: %+v\n", s.Time, s.Reading)
+	setGauges(&s.Reading, s.Time)
+	setLatest(&s.Reading, s.Time, 0)
+	addRuntime(s.LaserRuntime)
+}
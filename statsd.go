@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var pms_statsd_errors_total = promauto.With(registry).NewCounter(
+	prometheus.CounterOpts{
+		Name: "pms_statsd_errors_total",
+		Help: "Failed sends to -statsd-addr",
+	},
+)
+
+// statsdSender sends readings as StatsD gauges over UDP, tagging them in
+// DogStatsD's "|#key:value" extension with the labels the Prometheus
+// metrics have. UDP never waits on the server, so publish sends directly.
+type statsdSender struct {
+	prefix string
+	// tags are added to every line, e.g. "sensor_id:kitchen".
+	tags []string
+	addr string
+	// conn is nil until a dial succeeds.
+	conn net.Conn
+	// counters are sent as StatsD counts of how much they grew since the
+	// previous reading.
+	counters []statsdCounter
+}
+
+type statsdCounter struct {
+	name string
+	c    prometheus.Counter
+	last float64
+}
+
+// newStatsdSender dials addr, and if that fails, say because its name doesn't
+// resolve yet, publish tries again for each reading.
+func newStatsdSender(addr, prefix string) *statsdSender {
+	if prefix != "" {
+		prefix += "."
+	}
+	s := &statsdSender{
+		prefix: prefix,
+		addr:   addr,
+		counters: []statsdCounter{
+			{name: "received_packets", c: pms_received_packets},
+			{name: "packet_checksum_errors", c: pms_packet_checksum_errors},
+			{name: "skipped_bytes", c: pms_skipped_bytes},
+		},
+	}
+	if *sensorID != "" {
+		s.tags = []string{"sensor_id:" + *sensorID}
+	}
+	if err := s.dial(); err != nil {
+		log.Printf("statsd: %v\n", err)
+	}
+	return s
+}
+
+func (s *statsdSender) dial() error {
+	conn, err := net.Dial("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// line writes one StatsD line, e.g. "breathe.pms.x:5|g|#microns:2.5".
+func (s *statsdSender) line(b *bytes.Buffer, name, value, typ string, tags ...string) {
+	fmt.Fprintf(b, "%spms.%s:%s|%s", s.prefix, name, value, typ)
+	if tags = append(tags, s.tags...); len(tags) > 0 {
+		fmt.Fprintf(b, "|#%s", strings.Join(tags, ","))
+	}
+	b.WriteByte('\n')
+}
+
+// statsdLines formats a reading as StatsD lines, one per value.
+func (s *statsdSender) statsdLines(pms *PMS5003) []byte {
+	var b bytes.Buffer
+	gauge := func(name string, v uint16, tag string) {
+		s.line(&b, name, strconv.Itoa(int(v)), "g", tag)
+	}
+	gauge("particulate_matter_standard", pms.Pm10Std, "microns:"+pmLabel("1"))
+	gauge("particulate_matter_standard", pms.Pm25Std, "microns:"+pmLabel("2.5"))
+	gauge("particulate_matter_standard", pms.Pm100Std, "microns:"+pmLabel("10"))
+	gauge("particulate_matter_environmental", pms.Pm10Env, "microns:"+pmLabel("1"))
+	gauge("particulate_matter_environmental", pms.Pm25Env, "microns:"+pmLabel("2.5"))
+	gauge("particulate_matter_environmental", pms.Pm100Env, "microns:"+pmLabel("10"))
+	if pms.Length != pms3003Length {
+		for _, c := range pms.counts() {
+			if c.count == 0 && *suppressZeroCounts {
+				continue
+			}
+			gauge("particle_counts", c.count, "microns:"+countLabel(c.microns))
+		}
+	}
+	for i := range s.counters {
+		c := &s.counters[i]
+		v := counterValue(c.c)
+		if d := v - c.last; d > 0 {
+			s.line(&b, c.name, strconv.FormatFloat(d, 'f', -1, 64), "c")
+		}
+		c.last = v
+	}
+	return b.Bytes()
+}
+
+// publish sends a reading in one datagram, which StatsD servers split on
+// newlines. Without a connection the reading is dropped.
+func (s *statsdSender) publish(pms *PMS5003, t time.Time, seq uint64) {
+	// Format even when dropping, so counts since the previous reading don't
+	// pile up into the next one sent.
+	lines := s.statsdLines(pms)
+	if s.conn == nil {
+		if err := s.dial(); err != nil {
+			log.Printf("statsd: %v\n", err)
+			pms_statsd_errors_total.Inc()
+			return
+		}
+	}
+	if _, err := s.conn.Write(lines); err != nil {
+		// Usually an ICMP port unreachable from an earlier datagram.
+		log.Printf("statsd: %v\n", err)
+		pms_statsd_errors_total.Inc()
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// counterValue returns the current value of c.
+func counterValue(c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		return 0
+	}
+	return m.GetCounter().GetValue()
+}
+
+// logSummaries logs the latest reading and what the counters did since the
+// previous summary, every interval.
+func logSummaries(interval time.Duration) {
+	counters := []prometheus.Counter{pms_received_packets, pms_packet_checksum_errors, pms_skipped_bytes}
+	last := make([]float64, len(counters))
+	for i, c := range counters {
+		last[i] = counterValue(c)
+	}
+	for range time.Tick(interval) {
+		var delta [3]float64
+		for i, c := range counters {
+			v := counterValue(c)
+			delta[i], last[i] = v-last[i], v
+		}
+		latestMu.Lock()
+		l := latest
+		latestMu.Unlock()
+		if l == nil {
+			log.Printf("Summary: no reading yet; last %v: %.0f packets, %.0f checksum errors, %.0f skipped bytes\n",
+				interval, delta[0], delta[1], delta[2])
+			continue
+		}
+		pms := &l.Reading
+		log.Printf("Summary: PM2.5 %d, PM10 %d ug/m3 (AQI %.0f) as of %v; last %v: %.0f packets, %.0f checksum errors, %.0f skipped bytes\n",
+			pms.Pm25Env, pms.Pm100Env, pm25AQI(pms.Pm25Env), l.Time.Format(time.TimeOnly),
+			interval, delta[0], delta[1], delta[2])
+	}
+}
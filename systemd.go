@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends state to systemd over $NOTIFY_SOCKET, per sd_notify(3).
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return errors.New("NOTIFY_SOCKET not set, so the unit is probably not Type=notify")
+	}
+	conn, err := net.Dial("unixgram", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the WatchdogSec systemd set for us, or 0.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// notifySystemd tells systemd we're ready once the first valid reading since
+// start arrives, then pings its watchdog while readings stay fresh. Once no
+// reading has arrived for -stall-timeout, or the watchdog interval if that's
+// unset, the pings stop so systemd restarts us.
+func notifySystemd(start time.Time) {
+	for lastFrameTime().Before(start) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("systemd notify: %v\n", err)
+		return
+	}
+	log.Println("Notified systemd we're ready.")
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	staleAfter := *stallTimeout
+	if staleAfter == 0 {
+		staleAfter = interval
+	}
+	stale := false
+	for range time.Tick(interval / 2) {
+		if age := time.Since(lastFrameTime()); age > staleAfter {
+			if !stale {
+				log.Printf("No reading for %v, no longer pinging the systemd watchdog.\n", age.Round(time.Second))
+			}
+			stale = true
+			continue
+		}
+		stale = false
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Printf("systemd notify: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// timestampedCollector stamps the metrics of the collector it wraps with
+// when the latest reading was taken, for -timestamp-pm-metrics, so
+// Prometheus stores when a value was measured rather than when it was
+// scraped.
+type timestampedCollector struct {
+	prometheus.Collector
+}
+
+func (c timestampedCollector) Collect(ch chan<- prometheus.Metric) {
+	t := lastReadingTime()
+	if t.IsZero() {
+		c.Collector.Collect(ch)
+		return
+	}
+	inner := make(chan prometheus.Metric)
+	go func() {
+		c.Collector.Collect(inner)
+		close(inner)
+	}()
+	for m := range inner {
+		ch <- prometheus.NewMetricWithTimestamp(t, m)
+	}
+}
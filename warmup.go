@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pms_warmup_duration_seconds = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_warmup_duration_seconds",
+			Help: "Time from opening the serial port until PM2.5 readings last settled. Creeping up can indicate a failing fan",
+		},
+	)
+
+	pms_warmup_duration_seconds_histogram = promauto.With(registry).NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "pms_warmup_duration_seconds_histogram",
+			Help:    "Time from opening the serial port until PM2.5 readings settled",
+			Buckets: []float64{1, 2, 5, 10, 15, 20, 30, 45, 60, 120},
+		},
+	)
+)
+
+const (
+	// settledFrames consecutive readings within settledTolerance of each
+	// other count as settled.
+	settledFrames    = 3
+	settledTolerance = 2 // micrograms per cubic meter
+)
+
+// warmupTracker measures how long readings take to settle after connecting.
+type warmupTracker struct {
+	start   time.Time
+	last    uint16
+	stable  int
+	settled bool
+}
+
+func newWarmupTracker(start time.Time) *warmupTracker {
+	return &warmupTracker{start: start}
+}
+
+// observe records a valid reading taken at time t.
+func (w *warmupTracker) observe(pms *PMS5003, t time.Time) {
+	if w.settled {
+		return
+	}
+	diff := int(pms.Pm25Env) - int(w.last)
+	w.last = pms.Pm25Env
+	if diff < -settledTolerance || diff > settledTolerance {
+		w.stable = 1
+		return
+	}
+	w.stable++
+	if w.stable < settledFrames {
+		return
+	}
+	w.settled = true
+	d := t.Sub(w.start)
+	log.Printf("Readings settled %v after opening the serial port.\n", d.Round(time.Millisecond))
+	pms_warmup_duration_seconds.Set(d.Seconds())
+	pms_warmup_duration_seconds_histogram.Observe(d.Seconds())
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// lastReadingTime returns when the latest reading was taken, or the zero time
+// if there isn't one.
+func lastReadingTime() time.Time {
+	latestMu.Lock()
+	defer latestMu.Unlock()
+	if latest == nil {
+		return time.Time{}
+	}
+	return latest.Time
+}
+
+// watchForStalls logs when no reading has arrived for timeout, and with
+// -stale-on-timeout deletes the reading series so scrapes return no samples
+// and Prometheus marks them stale. They reappear with the next valid reading.
+func watchForStalls(timeout time.Duration) {
+	start := time.Now()
+	stalled := false
+	for range time.Tick(timeout / 4) {
+		last := lastFrameTime()
+		if last.Before(start) {
+			last = start
+		}
+		age := time.Since(last)
+		if age < timeout {
+			if stalled {
+				log.Println("Sensor recovered.")
+			}
+			stalled = false
+			continue
+		}
+		if stalled {
+			continue
+		}
+		stalled = true
+		log.Printf("Sensor stalled: no reading for %v.\n", age.Round(time.Second))
+		if *staleOnTimeout {
+			deleteReadingSeries()
+		}
+	}
+}
+
+// deleteReadingSeries deletes the reading series, so scrapes return no
+// samples for them and Prometheus marks them stale. The unlabelled gauges
+// can't be reset, so it unregisters them until restoreReadingSeries.
+func deleteReadingSeries() {
+	pms_particulate_matter_standard.Reset()
+	pms_particulate_matter_environmental.Reset()
+	pms_particulate_matter.Reset()
+	pms_particle_counts.Reset()
+	pms_aqi.Reset()
+	pms_env_std_ratio.Reset()
+	hiddenGaugesMu.Lock()
+	defer hiddenGaugesMu.Unlock()
+	for _, g := range []prometheus.Collector{
+		pms_pm25, pms_pm10, pms_pm25_delta, pms_pm25_humidity_corrected,
+		pms_pm25_calibrated, pms_sensor_version, pms_sensor_error_code,
+	} {
+		// Unregister is false for the optional gauges left unregistered.
+		if registry.Unregister(g) {
+			hiddenGauges = append(hiddenGauges, g)
+		}
+	}
+}
+
+// restoreReadingSeries registers the gauges deleteReadingSeries unregistered
+// again, once setGauges has given them the new reading.
+func restoreReadingSeries() {
+	hiddenGaugesMu.Lock()
+	defer hiddenGaugesMu.Unlock()
+	for _, g := range hiddenGauges {
+		registry.MustRegister(g)
+	}
+	hiddenGauges = nil
+}
+
+var (
+	pms_sensor_slow = promauto.With(registry).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pms_sensor_slow",
+			Help: "1 while no valid reading has arrived for -slow-threshold",
+		},
+	)
+
+	pms_dead_sensor_reconnects_total = promauto.With(registry).NewCounter(
+		prometheus.CounterOpts{
+			Name: "pms_dead_sensor_reconnects_total",
+			Help: "Times the serial port was reopened because no valid reading arrived for -dead-threshold",
+		},
+	)
+
+	// openPortMu guards openPort, the serial port readPort is reading, so
+	// the watchdog can close it to force a reconnect.
+	openPortMu sync.Mutex
+	openPort   io.Closer
+
+	// hiddenGaugesMu guards hiddenGauges, the gauges deleteReadingSeries
+	// unregistered.
+	hiddenGaugesMu sync.Mutex
+	hiddenGauges   []prometheus.Collector
+)
+
+// setOpenPort records the port being read, or nil once it's closed.
+func setOpenPort(c io.Closer) {
+	openPortMu.Lock()
+	defer openPortMu.Unlock()
+	openPort = c
+}
+
+// currentOpenPort returns the serial port readPort is reading, or nil.
+func currentOpenPort() io.Closer {
+	openPortMu.Lock()
+	defer openPortMu.Unlock()
+	return openPort
+}
+
+// watchCadence grades the time since the last reading: after slow it logs
+// and sets pms_sensor_slow, and after dead it closes the serial port so
+// readPortForever reopens it, again every dead for as long as no reading
+// arrives. Either threshold may be 0 to disable it.
+func watchCadence(slow, dead time.Duration) {
+	tick := slow
+	if tick == 0 || (dead > 0 && dead < tick) {
+		tick = dead
+	}
+	start := time.Now()
+	isSlow := false
+	var kicked time.Time
+	for range time.Tick(tick / 4) {
+		last := lastFrameTime()
+		if last.Before(start) {
+			last = start
+		}
+		age := time.Since(last)
+		if slow > 0 {
+			switch {
+			case age >= slow && !isSlow:
+				log.Printf("WARNING: sensor slow: no reading for %v.\n", age.Round(time.Second))
+				isSlow = true
+				pms_sensor_slow.Set(1)
+			case age < slow && isSlow:
+				log.Println("Sensor no longer slow.")
+				isSlow = false
+				pms_sensor_slow.Set(0)
+			}
+		}
+		if dead > 0 && age >= dead && time.Since(kicked) >= dead {
+			kicked = time.Now()
+			c := currentOpenPort()
+			if c == nil {
+				continue
+			}
+			log.Printf("Sensor dead: no reading for %v, reopening the serial port.\n", age.Round(time.Second))
+			pms_dead_sensor_reconnects_total.Inc()
+			c.Close()
+		}
+	}
+}
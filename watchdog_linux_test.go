@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY returns the master of a new pseudoterminal and the path of its
+// slave, a serial port whose sensor never says anything.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudoterminals: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatalf("unlocking the pty: %v", err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		t.Fatalf("getting the pty number: %v", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestDeadThresholdReopensSilentPort(t *testing.T) {
+	_, slave := openPTY(t)
+	// Not restored: readPortForever keeps reopening it after the test.
+	*portname = slave
+
+	before := counterValue(pms_serial_reconnects_total)
+	go readPortForever(newFrameQueue(1))
+	go watchCadence(0, 200*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for counterValue(pms_serial_reconnects_total) == before {
+		if time.Now().After(deadline) {
+			t.Fatalf("the silent serial port wasn't reopened; %v dead sensor kicks", counterValue(pms_dead_sensor_reconnects_total))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}